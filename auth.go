@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.uber.org/zap"
+)
+
+// metaKeyBitriseToken is the _meta field clients can use to send their
+// Bitrise PAT with the initialize request (for the whole session) or with a
+// single tool call.
+const metaKeyBitriseToken = "bitriseToken"
+
+const missingTokenMessage = "missing Bitrise personal access token: send it in the Authorization header " +
+	"of the MCP request or as _meta." + metaKeyBitriseToken + " of the initialize request"
+
+// sessionTokenTTL is how long the token of a session is kept after the
+// session last used it. The streamable HTTP transport doesn't unregister idle
+// sessions, so their tokens would be kept for the life of the process.
+const sessionTokenTTL = 24 * time.Hour
+
+// tenantAuth resolves the Bitrise PAT of every tool call in multi-tenant mode,
+// where the server never falls back to the configured token.
+type tenantAuth struct {
+	client   *bitrise.Client
+	validate bool
+	logger   *zap.SugaredLogger
+
+	now func() time.Time
+
+	mu sync.Mutex
+	// sessionPATs maps session IDs to the PAT sent in the initialize request's _meta.
+	sessionPATs map[string]sessionToken
+}
+
+// sessionToken is the PAT of a session, and when the session last used it.
+type sessionToken struct {
+	pat      string
+	lastUsed time.Time
+}
+
+func newTenantAuth(client *bitrise.Client, validate bool, logger *zap.SugaredLogger) *tenantAuth {
+	return &tenantAuth{
+		client:      client,
+		validate:    validate,
+		logger:      logger,
+		now:         time.Now,
+		sessionPATs: make(map[string]sessionToken),
+	}
+}
+
+func (a *tenantAuth) registerHooks(hooks *server.Hooks) {
+	hooks.AddOnRequestInitialization(a.onRequestInitialization)
+	hooks.AddOnUnregisterSession(func(_ context.Context, session server.ClientSession) {
+		a.forgetSession(session.SessionID())
+	})
+}
+
+// storeSessionPAT keeps the PAT of a session, and drops the ones of the
+// sessions that haven't used theirs for sessionTokenTTL.
+func (a *tenantAuth) storeSessionPAT(sessionID, pat string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for id, token := range a.sessionPATs {
+		if now.Sub(token.lastUsed) > sessionTokenTTL {
+			delete(a.sessionPATs, id)
+		}
+	}
+	a.sessionPATs[sessionID] = sessionToken{pat: pat, lastUsed: now}
+}
+
+// forgetSession drops the PAT of a session, once it is closed.
+func (a *tenantAuth) forgetSession(sessionID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessionPATs, sessionID)
+}
+
+// onRequestInitialization captures the session token of initialize requests
+// and, if enabled, validates it against the Bitrise API before the session starts.
+func (a *tenantAuth) onRequestInitialization(ctx context.Context, _ any, message any) error {
+	raw, ok := message.(json.RawMessage)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Method string `json:"method"`
+		Params struct {
+			Meta map[string]any `json:"_meta"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &req); err != nil || req.Method != string(mcp.MethodInitialize) {
+		return nil
+	}
+
+	pat := bitrise.PATFromContext(ctx)
+	if metaPAT, _ := req.Params.Meta[metaKeyBitriseToken].(string); metaPAT != "" {
+		pat = metaPAT
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			a.storeSessionPAT(session.SessionID(), metaPAT)
+		}
+	}
+
+	if !a.validate {
+		return nil
+	}
+	if pat == "" {
+		return errors.New(missingTokenMessage)
+	}
+	if err := bitrise.ValidatePAT(bitrise.ContextWithClient(ctx, a.client), pat); err != nil {
+		a.logger.Infow("rejected session with invalid token", "error", err)
+		if errors.Is(err, bitrise.ErrUnauthorized) {
+			return bitrise.ErrUnauthorized
+		}
+		return fmt.Errorf("validate Bitrise personal access token: %w", err)
+	}
+	return nil
+}
+
+// middleware attaches the PAT of the calling tenant to the context. The
+// tool call's own _meta takes precedence over the Authorization header, which
+// in turn takes precedence over the session's token.
+func (a *tenantAuth) middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pat := ""
+		if meta := request.Params.Meta; meta != nil {
+			pat, _ = meta.AdditionalFields[metaKeyBitriseToken].(string)
+		}
+		if pat == "" {
+			if session := server.ClientSessionFromContext(ctx); session != nil {
+				pat = a.sessionPAT(ctx, session.SessionID())
+			} else {
+				pat = bitrise.PATFromContext(ctx)
+			}
+		}
+		if pat == "" {
+			return mcp.NewToolResultError(missingTokenMessage), nil
+		}
+
+		return next(bitrise.ContextWithPAT(ctx, pat), request)
+	}
+}
+
+// sessionPAT returns the PAT of the Authorization header, or the one the
+// session sent when it started, unless it expired.
+func (a *tenantAuth) sessionPAT(ctx context.Context, sessionID string) string {
+	if pat := bitrise.PATFromContext(ctx); pat != "" {
+		return pat
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, ok := a.sessionPATs[sessionID]
+	if !ok {
+		return ""
+	}
+	now := a.now()
+	if now.Sub(token.lastUsed) > sessionTokenTTL {
+		delete(a.sessionPATs, sessionID)
+		return ""
+	}
+	token.lastUsed = now
+	a.sessionPATs[sessionID] = token
+	return token.pat
+}
+
+// contextWithSessionPAT attaches the PAT of the session to the context of
+// resource reads and subscriptions. Without a token, the API calls fail with
+// bitrise.ErrMissingPAT.
+func (a *tenantAuth) contextWithSessionPAT(ctx context.Context, sessionID string) context.Context {
+	if pat := a.sessionPAT(ctx, sessionID); pat != "" {
+		return bitrise.ContextWithPAT(ctx, pat)
+	}
+	return ctx
+}
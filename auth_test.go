@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.uber.org/zap"
+)
+
+func TestTenantAuthMiddleware(t *testing.T) {
+	auth := newTenantAuth(bitrise.NewClient(), false, zap.NewNop().Sugar())
+	mcpServer := server.NewMCPServer("test", "test")
+	sessionCtx := func(id string) context.Context {
+		return mcpServer.WithContext(context.Background(), server.NewInProcessSession(id, nil))
+	}
+	initialize := func(ctx context.Context, pat string) {
+		t.Helper()
+		message, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  mcp.MethodInitialize,
+			"params":  map[string]any{"_meta": map[string]any{metaKeyBitriseToken: pat}},
+		})
+		if err := auth.onRequestInitialization(ctx, nil, json.RawMessage(message)); err != nil {
+			t.Fatalf("initialize: %v", err)
+		}
+	}
+	// call returns the PAT the tool handler got, or the error of the middleware.
+	call := func(ctx context.Context, meta map[string]any) (string, string) {
+		t.Helper()
+		var got string
+		handler := auth.middleware(func(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			got = bitrise.PATFromContext(ctx)
+			return mcp.NewToolResultText("ok"), nil
+		})
+		var request mcp.CallToolRequest
+		if meta != nil {
+			request.Params.Meta = &mcp.Meta{AdditionalFields: meta}
+		}
+		res, err := handler(ctx, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.IsError {
+			return "", res.Content[0].(mcp.TextContent).Text
+		}
+		return got, ""
+	}
+
+	initialize(sessionCtx("with-token"), "session-token")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		meta    map[string]any
+		want    string
+		wantErr bool
+	}{
+		{name: "header token", ctx: bitrise.ContextWithPAT(sessionCtx("other"), "header-token"), want: "header-token"},
+		{name: "header token without session", ctx: bitrise.ContextWithPAT(context.Background(), "header-token"), want: "header-token"},
+		{name: "session token", ctx: sessionCtx("with-token"), want: "session-token"},
+		{name: "header over session token", ctx: bitrise.ContextWithPAT(sessionCtx("with-token"), "header-token"), want: "header-token"},
+		{name: "call token", ctx: bitrise.ContextWithPAT(sessionCtx("with-token"), "header-token"), meta: map[string]any{metaKeyBitriseToken: "call-token"}, want: "call-token"},
+		{name: "missing token", ctx: sessionCtx("other"), wantErr: true},
+		{name: "missing token without session", ctx: context.Background(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errText := call(tt.ctx, tt.meta)
+			if tt.wantErr {
+				if errText != missingTokenMessage {
+					t.Errorf("got token %q and error %q, want the missing token error", got, errText)
+				}
+				return
+			}
+			if got != tt.want || errText != "" {
+				t.Errorf("got token %q and error %q, want %q", got, errText, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantAuthSessionTokens(t *testing.T) {
+	auth := newTenantAuth(bitrise.NewClient(), false, zap.NewNop().Sugar())
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	auth.storeSessionPAT("a", "token-a")
+	auth.storeSessionPAT("b", "token-b")
+
+	// Using a token keeps it alive.
+	now = now.Add(sessionTokenTTL - time.Minute)
+	if got := auth.sessionPAT(ctx, "a"); got != "token-a" {
+		t.Errorf("got %q, want token-a", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := auth.sessionPAT(ctx, "a"); got != "token-a" {
+		t.Errorf("got %q for a recently used token, want token-a", got)
+	}
+	if got := auth.sessionPAT(ctx, "b"); got != "" {
+		t.Errorf("got %q for an expired token, want none", got)
+	}
+
+	// Storing a token drops the expired ones.
+	auth.storeSessionPAT("c", "token-c")
+	now = now.Add(sessionTokenTTL + time.Minute)
+	auth.storeSessionPAT("d", "token-d")
+	if len(auth.sessionPATs) != 1 {
+		t.Errorf("kept %d tokens, want only the new one", len(auth.sessionPATs))
+	}
+
+	// Closing the session drops its token.
+	closed := notifySessionClosed(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), auth.forgetSession)
+	req := httptest.NewRequest(http.MethodDelete, streamableEndpoint, nil)
+	req.Header.Set(server.HeaderKeySessionID, "d")
+	closed.ServeHTTP(httptest.NewRecorder(), req)
+	if got := auth.sessionPAT(ctx, "d"); got != "" {
+		t.Errorf("got %q after the session was closed, want none", got)
+	}
+}
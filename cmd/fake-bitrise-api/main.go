@@ -0,0 +1,85 @@
+// Command fake-bitrise-api serves a fake of the Bitrise machines API, which
+// simulates machines in local directories. Point the MCP server at it with
+// BITRISE_API_BASE_URL to develop and test agents without spending VM minutes.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/fakeapi"
+)
+
+// shutdownTimeout is how long running requests, like commands, may take to
+// finish once the process is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("error: %+v", err)
+	}
+}
+
+func run() error {
+	addr := flag.String("addr", "localhost:8090", "address to listen on")
+	rootDir := flag.String("root", "", "directory to create the machines in (a temporary directory by default)")
+	token := flag.String("token", "", "the only personal access token to accept (any token by default)")
+	maxMachines := flag.Int("max-machines", 1, "number of machines that can run at the same time")
+	bootTime := flag.Duration("boot-time", 0, "how long machines take to become ready after they are created")
+	flag.Parse()
+
+	opts := []fakeapi.Option{fakeapi.WithMaxMachines(*maxMachines), fakeapi.WithBootTime(*bootTime)}
+	if *rootDir != "" {
+		opts = append(opts, fakeapi.WithRootDir(*rootDir))
+	}
+	if *token != "" {
+		opts = append(opts, fakeapi.WithToken(*token))
+	}
+	srv, err := fakeapi.New(opts...)
+	if err != nil {
+		return fmt.Errorf("create fake API: %w", err)
+	}
+	serveErr := serve(*addr, srv)
+	// Removes the machines, and the root directory if it is a temporary one.
+	if err := srv.Close(); err != nil && serveErr == nil {
+		return fmt.Errorf("close fake API: %w", err)
+	}
+	return serveErr
+}
+
+// serve serves the fake API until the process is interrupted or terminated.
+func serve(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	httpServer := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("serving fake Bitrise API on http://%s", addr)
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		log.Printf("shutting down fake Bitrise API")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown: %w", err)
+		}
+		return nil
+	}
+}
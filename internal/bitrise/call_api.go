@@ -1,15 +1,10 @@
 package bitrise
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
 	"net/http"
 	"os"
-	"strings"
+	"time"
 )
 
 const userAgent = "bitrise-mcp-remote-sandbox/1.0"
@@ -29,61 +24,24 @@ type CallAPIParams struct {
 	Path    string
 	Params  map[string]string
 	Body    any
+	// Idempotent marks a non-idempotent HTTP method (e.g. POST) as safe to retry.
+	Idempotent bool
+	// Timeout overrides the client's timeout for a single attempt of this call.
+	Timeout time.Duration
 }
 
+// CallAPI calls the Bitrise API with the client attached to the context,
+// or with a default client if there is none.
 func CallAPI(ctx context.Context, p CallAPIParams) (string, error) {
-	apiKey, err := patFromCtx(ctx)
-	if err != nil {
-		return "", errors.New("set authorization header to your bitrise pat")
-	}
-
-	var reqBody io.Reader
-	if p.Body != nil {
-		a, err := json.Marshal(p.Body)
-		if err != nil {
-			return "", fmt.Errorf("marshal request body: %w", err)
-		}
-		reqBody = bytes.NewBuffer(a)
-	}
-
-	fullURL := p.BaseURL
-	if !strings.HasPrefix(p.Path, "/") {
-		fullURL += "/"
-	}
-	fullURL += p.Path
-
-	req, err := http.NewRequest(p.Method, fullURL, reqBody)
-	if err != nil {
-		return "", fmt.Errorf("create request: %w", err)
-	}
-	if p.Params != nil {
-		q := req.URL.Query()
-		for key, value := range p.Params {
-			q.Add(key, value)
-		}
-		req.URL.RawQuery = q.Encode()
-	}
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", apiKey)
+	return clientFromCtx(ctx).Call(ctx, p)
+}
 
-	client := http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("execute request: %w", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode >= 400 {
-		resBody, _ := io.ReadAll(res.Body)
-		return "", fmt.Errorf(
-			"unexpected status code %d; response body: %s",
-			res.StatusCode, resBody,
-		)
-	}
-	resBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		return "", fmt.Errorf("read response body: %w", err)
-	}
-	return string(resBody), nil
+// ValidatePAT checks the given personal access token against the Bitrise API.
+func ValidatePAT(ctx context.Context, pat string) error {
+	_, err := CallAPI(ContextWithPAT(ctx, pat), CallAPIParams{
+		Method:  http.MethodGet,
+		BaseURL: APIBaseURL(),
+		Path:    "/me",
+	})
+	return err
 }
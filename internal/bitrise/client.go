@@ -0,0 +1,282 @@
+package bitrise
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 5 * time.Minute
+	defaultMaxRetries = 3
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Client calls the Bitrise API. It is safe for concurrent use and should be
+// reused, so connections are pooled across calls.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	timeout    time.Duration
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithTimeout sets the timeout of a single attempt of an API call.
+// A zero timeout means no timeout.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithMaxRetries sets how many times a failed idempotent call is retried.
+func WithMaxRetries(maxRetries int) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+	}
+}
+
+// WithBackoff sets the bounds of the exponential backoff between retries.
+func WithBackoff(minBackoff, maxBackoff time.Duration) ClientOption {
+	return func(c *Client) {
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithBaseURL sets the base URL of the typed API clients, overriding APIBaseURL.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// NewClient creates a new Bitrise API client.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+				MaxIdleConnsPerHost: 10,
+			},
+		},
+		timeout:    defaultTimeout,
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BaseURL returns the base URL of the Bitrise API used by the typed API clients.
+func (c *Client) BaseURL() string {
+	if c.baseURL != "" {
+		return c.baseURL
+	}
+	return APIBaseURL()
+}
+
+// Call calls the Bitrise API. Idempotent calls are retried with exponential
+// backoff on rate limiting, server errors and network errors. A Retry-After
+// requested by the server is waited for if it is within the maximum backoff,
+// otherwise the APIError is returned with it. No retry is attempted if the
+// wait would outlast the deadline of ctx.
+func (c *Client) Call(ctx context.Context, p CallAPIParams) (string, error) {
+	apiKey, err := patFromCtx(ctx)
+	if err != nil || apiKey == "" {
+		return "", ErrMissingPAT
+	}
+
+	var reqBody []byte
+	if p.Body != nil {
+		reqBody, err = json.Marshal(p.Body)
+		if err != nil {
+			return "", fmt.Errorf("marshal request body: %w", err)
+		}
+	}
+
+	idempotent := p.Idempotent || isIdempotentMethod(p.Method)
+	for attempt := 0; ; attempt++ {
+		res, err := c.call(ctx, p, apiKey, reqBody)
+		if err == nil {
+			return res, nil
+		}
+		if !idempotent || attempt >= c.maxRetries || !isRetryable(ctx, err) {
+			return "", err
+		}
+
+		wait := c.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			// Retrying earlier than the server asked would only be rejected
+			// again, and waiting longer than the maximum backoff would block
+			// the call: the error is returned with the delay instead.
+			if apiErr.RetryAfter > c.maxBackoff {
+				return "", err
+			}
+			wait = apiErr.RetryAfter
+		}
+		// Waiting past the deadline would only replace the error with a timeout.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return "", err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// CallJSON calls the Bitrise API and decodes the JSON response into out.
+// out may be nil if the response body is not needed.
+func (c *Client) CallJSON(ctx context.Context, p CallAPIParams, out any) error {
+	res, err := c.Call(ctx, p)
+	if err != nil {
+		return err
+	}
+	if out == nil || strings.TrimSpace(res) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(res), out); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) call(ctx context.Context, p CallAPIParams, apiKey string, body []byte) (string, error) {
+	timeout := c.timeout
+	if p.Timeout > 0 {
+		timeout = p.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	fullURL := p.BaseURL
+	if !strings.HasPrefix(p.Path, "/") {
+		fullURL += "/"
+	}
+	fullURL += p.Path
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, fullURL, reqBody)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	if p.Params != nil {
+		q := req.URL.Query()
+		for key, value := range p.Params {
+			q.Add(key, value)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", apiKey)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("execute request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		resBody, _ := io.ReadAll(res.Body)
+		return "", newAPIError(res.StatusCode, string(resBody), parseRetryAfter(res.Header.Get("Retry-After")))
+	}
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response body: %w", err)
+	}
+	return string(resBody), nil
+}
+
+// backoff returns the exponential backoff before the given retry, with jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.minBackoff << attempt
+	if wait <= 0 || wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	// Add up to 20% jitter so concurrent clients don't retry in lockstep.
+	return wait + rand.N(wait/5+1)
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	// Network errors, including the timeout of a single attempt.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package bitrise
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	c := NewClient(WithBackoff(100*time.Millisecond, time.Second))
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{62, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			// Up to 20% jitter is added.
+			if got := c.backoff(tt.attempt); got < tt.want || got > tt.want+tt.want/5 {
+				t.Fatalf("backoff(%d) = %s, want %s plus up to 20%%", tt.attempt, got, tt.want)
+			}
+		}
+	}
+
+	if got := NewClient(WithBackoff(0, 0)).backoff(3); got != 0 {
+		t.Errorf("backoff without bounds = %s, want 0", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"rate limited", context.Background(), newAPIError(http.StatusTooManyRequests, "", 0), true},
+		{"server error", context.Background(), newAPIError(http.StatusBadGateway, "", 0), true},
+		{"not found", context.Background(), newAPIError(http.StatusNotFound, "", 0), false},
+		{"bad request", context.Background(), newAPIError(http.StatusBadRequest, "", 0), false},
+		{"network error", context.Background(), &url.Error{Op: "Get", URL: "https://api.bitrise.io", Err: errors.New("connection reset")}, true},
+		{"other error", context.Background(), errors.New("parse response"), false},
+		{"canceled context", canceled, newAPIError(http.StatusServiceUnavailable, "", 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+// retryServer responds with the given status to every request, and counts them.
+func retryServer(t *testing.T, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestCallRetries(t *testing.T) {
+	ctx := ContextWithPAT(context.Background(), "token")
+
+	ts, calls := retryServer(t, http.StatusServiceUnavailable, "")
+	c := NewClient(WithBackoff(time.Millisecond, 5*time.Millisecond), WithMaxRetries(2))
+	_, err := c.Call(ctx, CallAPIParams{Method: http.MethodGet, BaseURL: ts.URL, Path: "/me"})
+	if err == nil || calls.Load() != 3 {
+		t.Errorf("got %v after %d calls, want an error after 3", err, calls.Load())
+	}
+
+	calls.Store(0)
+	_, err = c.Call(ctx, CallAPIParams{Method: http.MethodPost, BaseURL: ts.URL, Path: "/platform/me/machines"})
+	if err == nil || calls.Load() != 1 {
+		t.Errorf("non-idempotent call: got %v after %d calls, want an error after 1", err, calls.Load())
+	}
+
+	// A Retry-After longer than the maximum backoff is returned, not retried early.
+	ts, calls = retryServer(t, http.StatusTooManyRequests, "3600")
+	start := time.Now()
+	_, err = c.Call(ctx, CallAPIParams{Method: http.MethodGet, BaseURL: ts.URL, Path: "/me"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Hour || calls.Load() != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("got %v after %d calls, want the API error with the Retry-After after 1", err, calls.Load())
+	}
+	if err != nil && !strings.Contains(err.Error(), "retry after 1h0m0s") {
+		t.Errorf("got error %q, want the retry delay in it", err)
+	}
+
+	// A short Retry-After is waited for.
+	ts, calls = retryServer(t, http.StatusServiceUnavailable, "1")
+	c = NewClient(WithBackoff(time.Millisecond, 2*time.Second), WithMaxRetries(1))
+	start = time.Now()
+	_, err = c.Call(ctx, CallAPIParams{Method: http.MethodGet, BaseURL: ts.URL, Path: "/me"})
+	if elapsed := time.Since(start); err == nil || calls.Load() != 2 || elapsed < time.Second {
+		t.Errorf("got %v after %d calls in %s, want an error after 2 calls a second apart", err, calls.Load(), elapsed)
+	}
+}
+
+func TestCallRetryCanceled(t *testing.T) {
+	ts, calls := retryServer(t, http.StatusServiceUnavailable, "")
+	c := NewClient(WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithCancel(ContextWithPAT(context.Background(), "token"))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := c.Call(ctx, CallAPIParams{Method: http.MethodGet, BaseURL: ts.URL, Path: "/me"})
+	var apiErr *APIError
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &apiErr) || calls.Load() != 1 {
+		t.Errorf("got %v after %d calls, want canceled with the last error after 1", err, calls.Load())
+	}
+
+	// A retry that would outlast the deadline isn't waited for.
+	ctx, cancel = context.WithTimeout(ContextWithPAT(context.Background(), "token"), time.Minute)
+	defer cancel()
+	calls.Store(0)
+	start := time.Now()
+	_, err = c.Call(ctx, CallAPIParams{Method: http.MethodGet, BaseURL: ts.URL, Path: "/me"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("got %v after %d calls, want the API error right away", err, calls.Load())
+	}
+}
@@ -0,0 +1,312 @@
+package bitrise
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxOutputBytes bounds stdout and stderr of a command returned by Run, each.
+const DefaultMaxOutputBytes = 256 * 1024
+
+// MaxCommandBytes bounds the script, the stdin and the environment variables
+// of a command together. Commands are sent to the machine as the argument of
+// bash -c, which the operating system limits in size.
+const MaxCommandBytes = 96 << 10
+
+// maxExecuteBytes bounds the script sent to the execute endpoint, which is the
+// command with the scripts that wrap it. Linux limits a single argument to
+// 128 KiB, macOS all of them together to 1 MiB.
+const maxExecuteBytes = 120 << 10
+
+// Command is a bash command to run on a machine with Run or RunJob.
+type Command struct {
+	// Script is run by bash.
+	Script string
+	// Dir is the working directory. The home directory is used if empty.
+	Dir string
+	// Env is exported to the command. Its values are masked in the results, see Mask.
+	Env map[string]string
+	// Stdin is the standard input of the command, which is empty by default.
+	Stdin string
+	// Timeout kills the command and the processes it started after the duration, if not zero.
+	Timeout time.Duration
+	// MaxOutputBytes bounds stdout and stderr, each. DefaultMaxOutputBytes is used if zero.
+	MaxOutputBytes int
+}
+
+// CommandResult is the outcome of a command run with Run.
+type CommandResult struct {
+	ExitCode   int    `json:"exit_code" jsonschema:"description=Exit code of the command: 0 on success\\, -1 if it could not be determined"`
+	Stdout     string `json:"stdout" jsonschema:"description=Standard output of the command"`
+	Stderr     string `json:"stderr" jsonschema:"description=Standard error of the command"`
+	DurationMs int64  `json:"duration_ms" jsonschema:"description=Wall-clock duration of the call in milliseconds"`
+	Truncated  bool   `json:"truncated" jsonschema:"description=True if stdout or stderr was cut to the output limit"`
+	TimedOut   bool   `json:"timed_out" jsonschema:"description=True if the command was killed because it ran longer than its timeout"`
+}
+
+// TimeoutExitCode is the exit code of commands killed because of their
+// timeout, the same as the one of timeout(1).
+const TimeoutExitCode = 124
+
+// timeoutMarker starts the line the timeout wrapper writes to stderr when it
+// killed the command. Timeouts are detected by it, not by the exit code,
+// which the command itself may exit with too (e.g. if it runs timeout(1)).
+// It is removed from the stderr returned.
+const timeoutMarker = "__bitrise_mcp_timed_out__ "
+
+// minMaskedLength is the length from which environment variable values are
+// masked in the output. Masking shorter values, like "1", would garble it.
+const minMaskedLength = 4
+
+const maskedValue = "***"
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks the options and the size of the command.
+func (c Command) Validate() error {
+	if err := c.validateOptions(); err != nil {
+		return err
+	}
+	size := len(c.Script) + len(c.Stdin)
+	for name, value := range c.Env {
+		size += len(name) + len(value)
+	}
+	if size > MaxCommandBytes {
+		return fmt.Errorf("the command is %d bytes with its stdin and environment variables, more than the limit of %d bytes: "+
+			"upload large inputs as files instead", size, MaxCommandBytes)
+	}
+	return nil
+}
+
+func (c Command) validateOptions() error {
+	for name := range c.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	if c.Timeout < 0 || c.Timeout > executeTimeout {
+		return fmt.Errorf("timeout must be between 0 and %s", executeTimeout)
+	}
+	return nil
+}
+
+// Mask replaces the values of the command's environment variables in s, so
+// secrets passed to the command are not echoed back.
+func (c Command) Mask(s string) string {
+	values := make([]string, 0, len(c.Env))
+	for _, value := range c.Env {
+		if len(value) >= minMaskedLength {
+			values = append(values, value)
+		}
+	}
+	// Longer values first, in case a value contains another one.
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, maskedValue)
+	}
+	return s
+}
+
+// shell returns a bash script that runs the command with its options.
+//
+// With a timeout, the command runs in its own process group, so the whole
+// group can be killed. Job control messages of bash ("Terminated") go to the
+// original stderr (fd 2), which is replaced with /dev/null, while the command
+// writes to the saved one (fd 3).
+func (c Command) shell() string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(c.Env)) {
+		fmt.Fprintf(&b, "export %s=%s\n", name, ShellQuote(c.Env[name]))
+	}
+	if c.Dir != "" {
+		fmt.Fprintf(&b, "cd -- %s || exit 1\n", ShellQuote(c.Dir))
+	}
+
+	// The script and the stdin are passed in here-documents, which are not
+	// limited in size like arguments, and bash reads the script from fd 5.
+	// Stdin is read from a file rather than piped, so the command is not part
+	// of a pipeline and $! below is the PID of the command itself. The file is
+	// removed as soon as it is opened.
+	script, scriptBody := hereDoc(c.Script)
+	run := "bash /dev/fd/5 </dev/null 5" + script
+	if c.Stdin != "" {
+		stdin, stdinBody := hereDoc(c.Stdin)
+		fmt.Fprintf(&b, "__i=$(mktemp) || exit 1\nhead -c %d >\"$__i\" %s\n%sexec 4<\"$__i\"\nrm -f \"$__i\"\n", len(c.Stdin), stdin, stdinBody)
+		run = "bash /dev/fd/5 <&4 4<&- 5" + script
+	}
+	if c.Timeout <= 0 {
+		b.WriteString(run + "\n" + scriptBody)
+		return b.String()
+	}
+
+	seconds := int(c.Timeout.Round(time.Second).Seconds())
+	fmt.Fprintf(&b, `__t=$(mktemp) || exit 1
+exec 3>&2 2>/dev/null
+set -m
+%s 2>&3 3>&- &
+%s__p=$!
+( sleep %d; echo 1 >"$__t"; kill -TERM -- -$__p; sleep 5; kill -KILL -- -$__p ) >/dev/null 2>&1 </dev/null 3>&- &
+__w=$!
+wait $__p
+__c=$?
+kill -- -$__w
+set +m
+exec 2>&3 3>&-
+if [ -s "$__t" ]; then
+  rm -f "$__t"
+  echo "%scommand timed out after %d seconds" >&2
+  exit %d
+fi
+rm -f "$__t"
+exit $__c`, run, scriptBody, seconds, timeoutMarker, seconds, TimeoutExitCode)
+	return b.String()
+}
+
+// hereDoc returns a here-document redirection of content, without the fd,
+// and its body, which goes on the lines after the command. The delimiter is
+// chosen so that it is not a line of content.
+func hereDoc(content string) (redirect, body string) {
+	delimiter := "__BITRISE_MCP_EOF"
+	for i := 1; strings.Contains("\n"+content+"\n", "\n"+delimiter+"\n"); i++ {
+		delimiter = fmt.Sprintf("__BITRISE_MCP_EOF_%d", i)
+	}
+	return "<<'" + delimiter + "'", content + "\n" + delimiter + "\n"
+}
+
+// finish masks the secrets in the result and records whether the timeout
+// wrapper killed the command, which is reported by the last line of its
+// stderr, see hasTimeoutMarker.
+func (c Command) finish(res *CommandResult, timedOut bool) {
+	res.TimedOut = c.Timeout > 0 && timedOut
+	if res.TimedOut {
+		if i := strings.LastIndex(res.Stderr, timeoutMarker); i >= 0 {
+			res.Stderr = res.Stderr[:i] + res.Stderr[i+len(timeoutMarker):]
+		}
+	}
+	res.Stdout = c.Mask(res.Stdout)
+	res.Stderr = c.Mask(res.Stderr)
+}
+
+// hasTimeoutMarker reports whether the last line of stderr is the one the
+// timeout wrapper writes.
+func hasTimeoutMarker(stderr string) bool {
+	stderr = strings.TrimSuffix(stderr, "\n")
+	return strings.HasPrefix(stderr[strings.LastIndexByte(stderr, '\n')+1:], timeoutMarker)
+}
+
+// Run runs a command on the machine like Execute, but captures its exit
+// code, stdout and stderr separately. The execute endpoint only returns the
+// combined output, so the command is wrapped in a script that writes the
+// streams into temporary files and prints them after a random marker.
+func (m *MachinesAPI) Run(ctx context.Context, machineID string, cmd Command) (*CommandResult, error) {
+	if err := cmd.validateOptions(); err != nil {
+		return nil, err
+	}
+	maxOutput := cmd.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = DefaultMaxOutputBytes
+	}
+	marker, err := newMarker()
+	if err != nil {
+		return nil, err
+	}
+
+	script := wrapCommand(cmd.shell(), marker, maxOutput)
+	if len(script) > maxExecuteBytes {
+		return nil, fmt.Errorf("the command is too large to run: %d bytes with its stdin and environment variables, "+
+			"more than the limit of %d bytes", len(script), maxExecuteBytes)
+	}
+
+	start := time.Now()
+	res, err := m.Execute(ctx, machineID, ExecuteRequest{BashCCommand: script})
+	if err != nil {
+		return nil, err
+	}
+	result, timedOut := parseCommandOutput(res.Output, marker)
+	result.DurationMs = time.Since(start).Milliseconds()
+	cmd.finish(result, timedOut)
+	return result, nil
+}
+
+func newMarker() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate output marker: %w", err)
+	}
+	return "__bitrise_mcp_" + hex.EncodeToString(b), nil
+}
+
+// wrapCommand returns a script that runs script and prints:
+//
+//	<marker> exit <code> stdout <bytes> stderr <bytes> timed_out <0 or 1>
+//	<stdout, up to maxOutput bytes>
+//	<marker> stderr
+//	<stderr, up to maxOutput bytes>
+//
+// timed_out is checked on the machine, as the line with the timeout marker
+// is at the end of stderr, which may be cut.
+func wrapCommand(script, marker string, maxOutput int) string {
+	redirect, body := hereDoc(script)
+	return fmt.Sprintf(`__o=$(mktemp) && __e=$(mktemp) || exit 1
+bash /dev/fd/5 >"$__o" 2>"$__e" 5%s
+%s__c=$?
+__t=0; tail -n 1 "$__e" | grep -q %s && __t=1
+printf '\n%s exit %%d stdout %%d stderr %%d timed_out %%d\n' "$__c" "$(($(wc -c <"$__o")))" "$(($(wc -c <"$__e")))" "$__t"
+head -c %d "$__o"
+printf '\n%s stderr\n'
+head -c %d "$__e"
+rm -f "$__o" "$__e"`, redirect, body, ShellQuote("^"+timeoutMarker), marker, maxOutput, marker, maxOutput)
+}
+
+// parseCommandOutput parses the output of a wrapped command, and returns
+// whether the timeout wrapper killed it. If the markers are missing, e.g.
+// because the command killed the wrapper, the whole output is returned as
+// stdout with exit code -1.
+func parseCommandOutput(output, marker string) (*CommandResult, bool) {
+	fallback := &CommandResult{ExitCode: -1, Stdout: output}
+
+	headerStart := strings.Index(output, "\n"+marker+" exit ")
+	if headerStart < 0 {
+		return fallback, false
+	}
+	rest := output[headerStart+1:]
+	headerEnd := strings.IndexByte(rest, '\n')
+	if headerEnd < 0 {
+		return fallback, false
+	}
+	// <marker> exit <code> stdout <bytes> stderr <bytes> timed_out <0 or 1>
+	fields := strings.Fields(rest[:headerEnd])
+	if len(fields) != 9 {
+		return fallback, false
+	}
+	exitCode, err1 := strconv.Atoi(fields[2])
+	stdoutSize, err2 := strconv.Atoi(fields[4])
+	stderrSize, err3 := strconv.Atoi(fields[6])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return fallback, false
+	}
+
+	stdout, stderr, ok := strings.Cut(rest[headerEnd+1:], "\n"+marker+" stderr\n")
+	if !ok {
+		return fallback, false
+	}
+	return &CommandResult{
+		ExitCode:  exitCode,
+		Stdout:    stdout,
+		Stderr:    stderr,
+		Truncated: len(stdout) < stdoutSize || len(stderr) < stderrSize,
+	}, fields[8] == "1"
+}
+
+// ShellQuote quotes s as a single bash word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -10,8 +10,12 @@ type ctxKey int
 const (
 	keyPAT ctxKey = iota
 	keyEnabledGroups
+	keyClient
 )
 
+// defaultClient is used for API calls when no client is attached to the context.
+var defaultClient = NewClient() //nolint:gochecknoglobals
+
 func patFromCtx(ctx context.Context) (string, error) {
 	v := ctx.Value(keyPAT)
 	u, ok := v.(string)
@@ -24,3 +28,39 @@ func patFromCtx(ctx context.Context) (string, error) {
 func ContextWithPAT(ctx context.Context, s string) context.Context {
 	return context.WithValue(ctx, keyPAT, s)
 }
+
+// PATFromContext returns the PAT attached to the context, or an empty string if there is none.
+func PATFromContext(ctx context.Context) string {
+	pat, _ := patFromCtx(ctx)
+	return pat
+}
+
+// HasPAT reports whether a PAT has already been attached to the context.
+func HasPAT(ctx context.Context) bool {
+	_, err := patFromCtx(ctx)
+	return err == nil
+}
+
+// ContextWithEnabledGroups limits the tools available in the context to the given groups.
+func ContextWithEnabledGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, keyEnabledGroups, groups)
+}
+
+// EnabledGroupsFromContext returns the tool groups enabled in the context.
+// The second return value is false if the context doesn't restrict the groups.
+func EnabledGroupsFromContext(ctx context.Context) ([]string, bool) {
+	groups, ok := ctx.Value(keyEnabledGroups).([]string)
+	return groups, ok
+}
+
+// ContextWithClient attaches the Bitrise API client to be used for API calls to the context.
+func ContextWithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, keyClient, c)
+}
+
+func clientFromCtx(ctx context.Context) *Client {
+	if c, ok := ctx.Value(keyClient).(*Client); ok && c != nil {
+		return c
+	}
+	return defaultClient
+}
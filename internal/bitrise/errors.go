@@ -0,0 +1,142 @@
+package bitrise
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingPAT is returned when no Bitrise personal access token is attached to the context.
+	ErrMissingPAT = errors.New("missing Bitrise personal access token: send it in the Authorization header of the MCP request")
+	// ErrUnauthorized is returned when the Bitrise API rejects the personal access token.
+	ErrUnauthorized = errors.New("invalid Bitrise personal access token")
+	// ErrNotFound is returned when the machine (or a path on it) doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrQuotaExceeded is returned when the user is not allowed to use more machines or resources.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrMachineNotReady is returned when the machine exists but can't serve the request yet.
+	ErrMachineNotReady = errors.New("machine is not ready")
+	// ErrJobNotFound is returned when a background job doesn't exist on the machine.
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// APIError is returned for Bitrise API responses with an error status code.
+// Use errors.Is with the Err* variables of this package to check its kind.
+type APIError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay requested by the Retry-After response header.
+	RetryAfter time.Duration
+
+	kind error
+}
+
+func newAPIError(statusCode int, body string, retryAfter time.Duration) *APIError {
+	return &APIError{
+		StatusCode: statusCode,
+		Body:       body,
+		RetryAfter: retryAfter,
+		kind:       classifyStatus(statusCode, body),
+	}
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("unexpected status code %d; response body: %s", e.StatusCode, e.Body)
+	if e.kind != nil {
+		msg = fmt.Sprintf("%s (status code %d); response body: %s", e.kind, e.StatusCode, e.Body)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf("; retry after %s", e.RetryAfter.Round(time.Second))
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// errorBody is the JSON body of the error responses of the API.
+type errorBody struct {
+	Message   string `json:"message"`
+	ErrorMsg  string `json:"error_msg"`
+	Code      string `json:"code"`
+	ErrorCode string `json:"error_code"`
+}
+
+// The error codes of the API, and the messages of the responses without one,
+// that identify an error kind. Messages are matched case-insensitively as
+// substrings, so they have to be specific: a rate limit or a size limit is not
+// a machine quota, and a forbidden action is not an invalid token.
+var (
+	quotaCodes    = []string{"quota_exceeded", "machine_limit_reached", "concurrency_limit_reached"}
+	quotaMessages = []string{"quota exceeded", "machine quota", "machine limit", "maximum number of machines", "can run at a time"}
+
+	notReadyCodes    = []string{"machine_not_ready"}
+	notReadyMessages = []string{"not ready", "is booting", "is provisioning", "still booting", "still provisioning"}
+
+	unauthorizedCodes    = []string{"unauthorized", "invalid_token", "token_expired"}
+	unauthorizedMessages = []string{"invalid token", "invalid personal access token", "token expired", "token has expired", "token is invalid"}
+)
+
+// classifyStatus maps an error response to one of the error kinds. The
+// status code decides where it is unambiguous, otherwise the error code or
+// the message of the response, within the status codes the kind can come
+// with. Responses that don't match a kind are left unclassified, so the
+// agent isn't given a misleading hint.
+func classifyStatus(statusCode int, body string) error {
+	code, message := parseErrorBody(body)
+	matches := func(codes, messages []string) bool {
+		if code != "" && slices.Contains(codes, code) {
+			return true
+		}
+		return slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(message, m) })
+	}
+
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusPaymentRequired:
+		return ErrQuotaExceeded
+	case http.StatusTooEarly:
+		return ErrMachineNotReady
+	case http.StatusForbidden:
+		switch {
+		case matches(quotaCodes, quotaMessages):
+			return ErrQuotaExceeded
+		case matches(unauthorizedCodes, unauthorizedMessages):
+			return ErrUnauthorized
+		}
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		switch {
+		case matches(quotaCodes, quotaMessages):
+			return ErrQuotaExceeded
+		case matches(notReadyCodes, notReadyMessages):
+			return ErrMachineNotReady
+		}
+	case http.StatusLocked, http.StatusServiceUnavailable:
+		if matches(notReadyCodes, notReadyMessages) {
+			return ErrMachineNotReady
+		}
+	}
+	return nil
+}
+
+// parseErrorBody returns the lower-cased error code and message of an error
+// response. Bodies that aren't JSON are used as the message.
+func parseErrorBody(body string) (code, message string) {
+	var parsed errorBody
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+		return "", strings.ToLower(body)
+	}
+	code = cmp.Or(parsed.ErrorCode, parsed.Code)
+	message = cmp.Or(parsed.Message, parsed.ErrorMsg)
+	return strings.ToLower(code), strings.ToLower(message)
+}
@@ -0,0 +1,54 @@
+package bitrise
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"message":"Unauthorized"}`, ErrUnauthorized},
+		{"not found", http.StatusNotFound, `{"message":"machine abc not found"}`, ErrNotFound},
+		{"payment required", http.StatusPaymentRequired, "", ErrQuotaExceeded},
+		{"too early", http.StatusTooEarly, `{"message":"machine abc is not ready yet: booting"}`, ErrMachineNotReady},
+
+		{"machine limit", http.StatusConflict, `{"message":"machine limit reached: only 1 machine(s) can run at a time"}`, ErrQuotaExceeded},
+		{"quota code", http.StatusForbidden, `{"error_code":"QUOTA_EXCEEDED","message":"Forbidden"}`, ErrQuotaExceeded},
+		{"quota message", http.StatusForbidden, "Machine quota exceeded", ErrQuotaExceeded},
+		{"invalid token", http.StatusForbidden, `{"message":"Invalid token"}`, ErrUnauthorized},
+		{"token code", http.StatusForbidden, `{"code":"token_expired"}`, ErrUnauthorized},
+		{"forbidden action", http.StatusForbidden, `{"message":"You don't have access to this workspace"}`, nil},
+		{"not ready code", http.StatusConflict, `{"code":"machine_not_ready","message":"try later"}`, ErrMachineNotReady},
+		{"still booting", http.StatusServiceUnavailable, `{"message":"The machine is still booting"}`, ErrMachineNotReady},
+
+		{"rate limit", http.StatusTooManyRequests, `{"message":"rate limit exceeded"}`, nil},
+		{"size limit", http.StatusRequestEntityTooLarge, `{"message":"request exceeds the size limit"}`, nil},
+		{"limit in bad request", http.StatusBadRequest, `{"message":"lifetime must be within the limit of 240 minutes"}`, nil},
+		{"quota outside of its statuses", http.StatusInternalServerError, `{"message":"quota exceeded"}`, nil},
+		{"server error", http.StatusBadGateway, "<html>Bad Gateway</html>", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyStatus(tt.status, tt.body); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIErrorIs(t *testing.T) {
+	err := error(newAPIError(http.StatusConflict, `{"message":"machine limit reached"}`, 0))
+	if !errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("%v: want only ErrQuotaExceeded", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("%v: want an APIError with status 409", err)
+	}
+}
@@ -0,0 +1,386 @@
+package bitrise
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Job states.
+const (
+	JobRunning  = "running"
+	JobExited   = "exited"
+	JobCanceled = "canceled"
+	// JobLost is the state of jobs that stopped without recording their exit code,
+	// e.g. because they were killed by something else than a cancel call.
+	JobLost = "lost"
+)
+
+// jobsDir is the directory on the machine the jobs keep their files in.
+const jobsDir = "$HOME/.bitrise-mcp/jobs"
+
+// DefaultJobOutputBytes is how many bytes of each stream ReadJobOutput returns by default.
+const DefaultJobOutputBytes = 64 * 1024
+
+var jobIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// JobSignals returns the signals a job can be canceled with.
+func JobSignals() []string {
+	return []string{"TERM", "INT", "HUP", "QUIT", "KILL"}
+}
+
+// JobStatus is the state of a background job.
+type JobStatus struct {
+	JobID           string    `json:"job_id" jsonschema:"description=ID of the job"`
+	State           string    `json:"state" jsonschema:"enum=running,enum=exited,enum=canceled,enum=lost,description=State of the job"`
+	ExitCode        *int      `json:"exit_code,omitempty" jsonschema:"description=Exit code of the command\\, set once the job exited"`
+	Signal          string    `json:"signal,omitempty" jsonschema:"description=Signal the job was canceled with"`
+	StartedAt       time.Time `json:"started_at" jsonschema:"description=When the job was started"`
+	DurationSeconds int64     `json:"duration_seconds" jsonschema:"description=How long the job has been running\\, or ran until it exited"`
+	StdoutBytes     int64     `json:"stdout_bytes" jsonschema:"description=Size of the standard output written so far"`
+	StderrBytes     int64     `json:"stderr_bytes" jsonschema:"description=Size of the standard error written so far"`
+}
+
+// JobOutput is a chunk of the output of a background job.
+type JobOutput struct {
+	JobStatus
+	Stdout           string `json:"stdout" jsonschema:"description=Standard output from stdout_offset"`
+	Stderr           string `json:"stderr" jsonschema:"description=Standard error from stderr_offset"`
+	NextStdoutOffset int64  `json:"next_stdout_offset" jsonschema:"description=stdout_offset to use to read the next chunk of standard output"`
+	NextStderrOffset int64  `json:"next_stderr_offset" jsonschema:"description=stderr_offset to use to read the next chunk of standard error"`
+}
+
+// JobOutputRequest selects the chunk of output ReadJobOutput returns.
+type JobOutputRequest struct {
+	StdoutOffset int64
+	StderrOffset int64
+	// MaxBytes bounds each stream. DefaultJobOutputBytes is used if zero.
+	MaxBytes int
+}
+
+// StartJob starts a command with bash -c in the background and returns
+// without waiting for it. The job runs in its own process group with its
+// output redirected to files, so it keeps running after the call returns and
+// can't block the execute endpoint.
+func (m *MachinesAPI) StartJob(ctx context.Context, machineID string, cmd Command) (*JobStatus, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generate job ID: %w", err)
+	}
+	jobID := hex.EncodeToString(b)
+
+	// The command file is removed once opened, as it may contain secrets.
+	wrapper := `d="$1"; exec 5<"$d/command"; rm -f "$d/command"; bash /dev/fd/5 >"$d/stdout" 2>"$d/stderr" </dev/null; ` +
+		`c=$?; date +%s >"$d/finished_at"; echo $c >"$d/exit_code.tmp" && mv "$d/exit_code.tmp" "$d/exit_code"`
+	redirect, body := hereDoc(cmd.shell())
+	start := fmt.Sprintf(`d=%s/%s
+mkdir -p "$d" || exit 1
+cat >"$d/command" %s
+%s: >"$d/stdout"; : >"$d/stderr"
+date +%%s >"$d/started_at"
+set -m
+nohup bash -c %s job "$d" >/dev/null 2>&1 </dev/null &
+echo $! >"$d/pid"
+%s`, jobsDir, jobID, redirect, body, ShellQuote(wrapper), jobStatusScript)
+
+	res, err := m.Run(ctx, machineID, Command{Script: start})
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("start job: exit code %d: %s", res.ExitCode, res.Stderr)
+	}
+	return parseJobStatus(jobID, res.Stdout)
+}
+
+// GetJobStatus returns the state of a job started with StartJob.
+func (m *MachinesAPI) GetJobStatus(ctx context.Context, machineID, jobID string) (*JobStatus, error) {
+	if err := validateJobID(jobID); err != nil {
+		return nil, err
+	}
+	res, err := m.Run(ctx, machineID, Command{Script: jobScript(jobID, jobStatusScript)})
+	if err != nil {
+		return nil, err
+	}
+	return parseJobStatus(jobID, res.Stdout)
+}
+
+// ReadJobOutput returns the state of a job and its output from the given offsets.
+// The state is captured before the output is read, so once a job exited, the
+// output returned up to stdout_bytes and stderr_bytes is complete.
+func (m *MachinesAPI) ReadJobOutput(ctx context.Context, machineID, jobID string, req JobOutputRequest) (*JobOutput, error) {
+	if err := validateJobID(jobID); err != nil {
+		return nil, err
+	}
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultJobOutputBytes
+	}
+	// The status goes to stderr first, followed by the stderr chunk, and the
+	// byte counts are computed on the machine, so offsets stay exact even if
+	// a chunk is not valid UTF-8.
+	read := fmt.Sprintf(`{ %s; } >&2
+chunk() { local n=$(($(wc -c <"$1") - $2)); [ $n -gt %d ] && n=%d; [ $n -lt 0 ] && n=0; echo $n; }
+no=$(chunk "$d/stdout" %d); ne=$(chunk "$d/stderr" %d)
+echo "stdout_read $no" >&2; echo "stderr_read $ne" >&2; echo "end" >&2
+tail -c +%d "$d/stdout" | head -c $no
+tail -c +%d "$d/stderr" | head -c $ne >&2`,
+		jobStatusScript, maxBytes, maxBytes, req.StdoutOffset, req.StderrOffset, req.StdoutOffset+1, req.StderrOffset+1)
+
+	res, err := m.Run(ctx, machineID, Command{Script: jobScript(jobID, read), MaxOutputBytes: maxBytes + 4096})
+	if err != nil {
+		return nil, err
+	}
+	header, stderr, _ := strings.Cut(res.Stderr, "end\n")
+	status, err := parseJobStatus(jobID, header)
+	if err != nil {
+		return nil, err
+	}
+	fields := parseFields(header)
+	stdoutRead, _ := strconv.ParseInt(fields["stdout_read"], 10, 64)
+	stderrRead, _ := strconv.ParseInt(fields["stderr_read"], 10, 64)
+	return &JobOutput{
+		JobStatus:        *status,
+		Stdout:           res.Stdout,
+		Stderr:           stderr,
+		NextStdoutOffset: req.StdoutOffset + stdoutRead,
+		NextStderrOffset: req.StderrOffset + stderrRead,
+	}, nil
+}
+
+// CancelJob sends a signal to the process groups of a running job.
+func (m *MachinesAPI) CancelJob(ctx context.Context, machineID, jobID, signal string) (*JobStatus, error) {
+	if err := validateJobID(jobID); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(JobSignals(), signal) {
+		return nil, fmt.Errorf("unsupported signal %q (supported signals: %v)", signal, JobSignals())
+	}
+	// Commands with a timeout run in a process group of their own inside the
+	// one of the job, so the signal goes to the groups of all processes
+	// descending from the job. They are collected before any is signaled, as
+	// the processes are reparented once their parents exit.
+	cancel := fmt.Sprintf(`if [ ! -f "$d/exit_code" ]; then
+  echo %[1]s >"$d/canceled"
+  p=$(cat "$d/pid")
+  g=$(ps -A -o pid= -o ppid= -o pgid= | awk -v root="$p" '
+    { parent[$1] = $2; group[$1] = $3 }
+    END { for (x in parent) { y = x; while (y != root && (y in parent) && parent[y] != y) y = parent[y]; if (y == root) print group[x] } }' | sort -u)
+  for i in $g; do kill -s %[1]s -- -"$i" 2>/dev/null; done
+  kill -s %[1]s "$p" 2>/dev/null
+  sleep 1
+fi
+%[2]s`, signal, jobStatusScript)
+
+	res, err := m.Run(ctx, machineID, Command{Script: jobScript(jobID, cancel)})
+	if err != nil {
+		return nil, err
+	}
+	return parseJobStatus(jobID, res.Stdout)
+}
+
+// jobStatusScript prints the state of the job in $d as "key value" lines.
+const jobStatusScript = `[ -d "$d" ] || { echo missing; exit 0; }
+echo "now $(date +%s)"
+echo "started_at $(cat "$d/started_at")"
+[ -f "$d/finished_at" ] && echo "finished_at $(cat "$d/finished_at")"
+[ -f "$d/exit_code" ] && echo "exit_code $(cat "$d/exit_code")"
+[ -f "$d/canceled" ] && echo "signal $(cat "$d/canceled")"
+case "$(ps -o stat= -p "$(cat "$d/pid")" 2>/dev/null | tr -d " ")" in ""|Z*) ;; *) echo "running 1" ;; esac
+echo "stdout_bytes $(($(wc -c <"$d/stdout")))"
+echo "stderr_bytes $(($(wc -c <"$d/stderr")))"`
+
+// jobScript returns a script that runs body with $d set to the directory of the job.
+func jobScript(jobID, body string) string {
+	return fmt.Sprintf("d=%s/%s\n[ -d \"$d\" ] || { echo missing; exit 0; }\n%s", jobsDir, jobID, body)
+}
+
+func validateJobID(jobID string) error {
+	if !jobIDPattern.MatchString(jobID) {
+		return fmt.Errorf("%w: invalid job ID %q", ErrJobNotFound, jobID)
+	}
+	return nil
+}
+
+func parseJobStatus(jobID, output string) (*JobStatus, error) {
+	fields := parseFields(output)
+	if _, ok := fields["missing"]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	now, err := strconv.ParseInt(fields["now"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected job status output: %q", output)
+	}
+	startedAt, _ := strconv.ParseInt(fields["started_at"], 10, 64)
+	status := &JobStatus{
+		JobID:     jobID,
+		Signal:    fields["signal"],
+		StartedAt: time.Unix(startedAt, 0).UTC(),
+	}
+	status.StdoutBytes, _ = strconv.ParseInt(fields["stdout_bytes"], 10, 64)
+	status.StderrBytes, _ = strconv.ParseInt(fields["stderr_bytes"], 10, 64)
+
+	end := now
+	if finishedAt, err := strconv.ParseInt(fields["finished_at"], 10, 64); err == nil {
+		end = finishedAt
+	}
+	status.DurationSeconds = end - startedAt
+
+	_, running := fields["running"]
+	exitCode, exitErr := strconv.Atoi(fields["exit_code"])
+	switch {
+	case exitErr == nil:
+		status.State = JobExited
+		status.ExitCode = &exitCode
+	case running:
+		status.State = JobRunning
+	case status.Signal != "":
+		status.State = JobCanceled
+	default:
+		status.State = JobLost
+	}
+	return status, nil
+}
+
+// parseFields parses "key value" lines.
+func parseFields(output string) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if key != "" {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+// maxTimeoutLineBytes bounds the line the timeout wrapper writes to stderr.
+const maxTimeoutLineBytes = 128
+
+// Polling intervals of RunJob. The interval grows while the job produces no
+// output and resets once it does.
+const (
+	minJobPollInterval = 250 * time.Millisecond
+	maxJobPollInterval = 2 * time.Second
+)
+
+// RunJob runs a command as a background job and waits for it to exit, like
+// Run, but calls onOutput with the new stdout and stderr every time the job
+// produced output. The output passed to onOutput is not masked, see
+// Command.Mask. If ctx is canceled, the job is canceled too.
+func (m *MachinesAPI) RunJob(ctx context.Context, machineID string, cmd Command, onOutput func(stdout, stderr string)) (*CommandResult, error) {
+	maxOutput := cmd.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = DefaultMaxOutputBytes
+	}
+
+	start := time.Now()
+	status, err := m.StartJob(ctx, machineID, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr strings.Builder
+	// stderrTail is the end of stderr, which may be cut in the result, to
+	// find the line of the timeout wrapper.
+	var stderrTail string
+	// The chunks are cut at byte offsets, so a character can be split between
+	// two of them. Its start is held back from onOutput until the next chunk.
+	var pendingStdout, pendingStderr string
+	result := &CommandResult{ExitCode: -1}
+	collect := func(b *strings.Builder, s string) {
+		if room := maxOutput - b.Len(); len(s) > room {
+			s = s[:max(room, 0)]
+			result.Truncated = true
+		}
+		b.WriteString(s)
+	}
+
+	req := JobOutputRequest{}
+	interval := minJobPollInterval
+	for {
+		select {
+		case <-ctx.Done():
+			cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+			_, _ = m.CancelJob(cancelCtx, machineID, status.JobID, "TERM")
+			cancel()
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		output, err := m.ReadJobOutput(ctx, machineID, status.JobID, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return nil, err
+		}
+		req.StdoutOffset, req.StderrOffset = output.NextStdoutOffset, output.NextStderrOffset
+
+		if output.Stdout != "" || output.Stderr != "" {
+			collect(&stdout, output.Stdout)
+			collect(&stderr, output.Stderr)
+			stderrTail += output.Stderr
+			stderrTail = stderrTail[max(len(stderrTail)-maxTimeoutLineBytes, 0):]
+			if onOutput != nil {
+				var outChunk, errChunk string
+				outChunk, pendingStdout = splitIncompleteRune(pendingStdout + output.Stdout)
+				errChunk, pendingStderr = splitIncompleteRune(pendingStderr + output.Stderr)
+				if outChunk != "" || errChunk != "" {
+					onOutput(outChunk, strings.Replace(errChunk, timeoutMarker, "", 1))
+				}
+			}
+			interval = minJobPollInterval
+		} else {
+			interval = min(2*interval, maxJobPollInterval)
+		}
+
+		caughtUp := req.StdoutOffset >= output.StdoutBytes && req.StderrOffset >= output.StderrBytes
+		if output.State != JobRunning && caughtUp {
+			if output.ExitCode != nil {
+				result.ExitCode = *output.ExitCode
+			}
+			break
+		}
+		if !caughtUp {
+			interval = 0
+		}
+	}
+
+	if onOutput != nil && (pendingStdout != "" || pendingStderr != "") {
+		// The output ended in the middle of a character.
+		onOutput(pendingStdout, pendingStderr)
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.DurationMs = time.Since(start).Milliseconds()
+	cmd.finish(result, hasTimeoutMarker(stderrTail))
+	return result, nil
+}
+
+// splitIncompleteRune splits s before the incomplete UTF-8 encoded character
+// it ends with, if any.
+func splitIncompleteRune(s string) (complete, rest string) {
+	for i := len(s) - 1; i >= max(len(s)-utf8.UTFMax, 0); i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return s[:i], s[i:]
+			}
+			break
+		}
+	}
+	return s, ""
+}
@@ -0,0 +1,32 @@
+package bitrise
+
+import "testing"
+
+func TestSplitIncompleteRune(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		complete string
+		rest     string
+	}{
+		{"empty", "", "", ""},
+		{"ascii", "hello\n", "hello\n", ""},
+		{"complete runes", "héllo ✓ 🚀", "héllo ✓ 🚀", ""},
+		{"split 2-byte rune", "caf\xc3", "caf", "\xc3"},
+		{"split 3-byte rune after 1 byte", "ok \xe2", "ok ", "\xe2"},
+		{"split 3-byte rune after 2 bytes", "ok \xe2\x9c", "ok ", "\xe2\x9c"},
+		{"split 4-byte rune after 3 bytes", "go \xf0\x9f\x9a", "go ", "\xf0\x9f\x9a"},
+		{"only an incomplete rune", "\xf0\x9f", "", "\xf0\x9f"},
+		// Invalid bytes can't be completed by the next chunk, so they are not held back.
+		{"stray continuation byte", "abc\x80", "abc\x80", ""},
+		{"invalid start byte", "abc\xff", "abc\xff", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complete, rest := splitIncompleteRune(tt.in)
+			if complete != tt.complete || rest != tt.rest {
+				t.Errorf("splitIncompleteRune(%q) = %q, %q, want %q, %q", tt.in, complete, rest, tt.complete, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package bitrise
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// executeTimeout bounds a single command execution. Commands can run as long
+// as the remote machine lives.
+const executeTimeout = time.Hour
+
+// MachinesAPI is a typed client of the /platform/me/machines endpoints.
+type MachinesAPI struct {
+	client *Client
+}
+
+// Machines returns the machines API client using the client attached to the context.
+func Machines(ctx context.Context) *MachinesAPI {
+	return &MachinesAPI{client: clientFromCtx(ctx)}
+}
+
+type ListMachinesResponse struct {
+	MachineIDs []string `json:"machine_ids"`
+}
+
+// The lifecycle states of a machine.
+const (
+	MachineStateProvisioning = "provisioning"
+	MachineStateBooting      = "booting"
+	MachineStateReady        = "ready"
+	MachineStateTerminating  = "terminating"
+)
+
+// Machine describes a machine of the authenticated user.
+type Machine struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	// CreatedAt is when the machine was requested, ExpiresAt is when it is
+	// deleted automatically at the end of its lifetime.
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	MacOSVersion string    `json:"macosVersion"`
+	XcodeVersion string    `json:"xcodeVersion"`
+	// Stack and MachineType are the IDs of the image and of the hardware of the machine.
+	Stack       string `json:"stack"`
+	MachineType string `json:"machineType"`
+	// IPAddress is empty until the machine has booted.
+	IPAddress  string `json:"ipAddress"`
+	VNCEnabled bool   `json:"vncEnabled"`
+}
+
+// MachineSpecs is the catalog of the stacks and machine types machines can
+// be created with.
+type MachineSpecs struct {
+	Stacks       []Stack       `json:"stacks"`
+	MachineTypes []MachineType `json:"machineTypes"`
+	// DefaultStack and DefaultMachineType are used if a machine is created
+	// without them.
+	DefaultStack       string `json:"defaultStack"`
+	DefaultMachineType string `json:"defaultMachineType"`
+	// DefaultLifetimeMinutes and MaxLifetimeMinutes bound the lifetime of
+	// machines. There is no upper bound if MaxLifetimeMinutes is 0.
+	DefaultLifetimeMinutes int `json:"defaultLifetimeMinutes"`
+	MaxLifetimeMinutes     int `json:"maxLifetimeMinutes"`
+}
+
+// Stack is an image machines can be created with.
+type Stack struct {
+	ID           string `json:"id"`
+	MacOSVersion string `json:"macosVersion"`
+	XcodeVersion string `json:"xcodeVersion"`
+}
+
+// MachineType is the hardware machines can be created with.
+type MachineType struct {
+	ID       string `json:"id"`
+	CPUCount int    `json:"cpuCount"`
+	RAMGB    int    `json:"ramGb"`
+}
+
+// CreateMachineRequest selects the specs of a new machine. The defaults of
+// the API are used for the empty fields.
+type CreateMachineRequest struct {
+	Stack           string `json:"stack,omitempty"`
+	MachineType     string `json:"machineType,omitempty"`
+	LifetimeMinutes int    `json:"lifetimeMinutes,omitempty"`
+}
+
+type CreateMachineResponse struct {
+	MachineID string `json:"machine_id"`
+}
+
+type ExecuteRequest struct {
+	BashCCommand string `json:"bashCCommand"`
+}
+
+type ExecuteResponse struct {
+	Output string `json:"output"`
+}
+
+type StartUploadResponse struct {
+	SignedURL string `json:"signedUrl"`
+	UploadID  string `json:"uploadId"`
+}
+
+type CompleteUploadRequest struct {
+	UploadID                string `json:"uploadId"`
+	DestinationParentFolder string `json:"destinationParentFolder"`
+}
+
+type DownloadRequest struct {
+	SourcePath           string `json:"sourcePath"`
+	OnlyContentsOfFolder bool   `json:"onlyContentsOfFolder"`
+}
+
+type DownloadResponse struct {
+	SignedURL string `json:"signedUrl"`
+}
+
+type ScreenshotResponse struct {
+	SignedURL string `json:"signedUrl"`
+}
+
+type ClickRequest struct {
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+	Button      string `json:"button"`
+	DoubleClick bool   `json:"doubleClick,omitempty"`
+}
+
+type TypeRequest struct {
+	Text string `json:"text"`
+}
+
+type ScrollRequest struct {
+	Direction string `json:"direction"`
+	Amount    int    `json:"amount"`
+}
+
+type MouseDragRequest struct {
+	StartX int `json:"startX"`
+	StartY int `json:"startY"`
+	EndX   int `json:"endX"`
+	EndY   int `json:"endY"`
+}
+
+type OpenVNCResponse struct {
+	VNCAddress  string `json:"vncAddress"`
+	VNCUsername string `json:"vncUsername"`
+	VNCPassword string `json:"vncPassword"`
+}
+
+// List returns the IDs of the machines of the authenticated user.
+func (m *MachinesAPI) List(ctx context.Context) (*ListMachinesResponse, error) {
+	var res ListMachinesResponse
+	if err := m.call(ctx, http.MethodGet, "", "", nil, &res, false); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Get returns the state and the details of the machine.
+func (m *MachinesAPI) Get(ctx context.Context, machineID string) (*Machine, error) {
+	var res Machine
+	if err := m.call(ctx, http.MethodGet, machineID, "", nil, &res, true); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Specs returns the stacks and machine types machines can be created with.
+func (m *MachinesAPI) Specs(ctx context.Context) (*MachineSpecs, error) {
+	var res MachineSpecs
+	err := m.client.CallJSON(ctx, CallAPIParams{
+		Method:     http.MethodGet,
+		BaseURL:    m.client.BaseURL(),
+		Path:       "/platform/me/machine_specs",
+		Idempotent: true,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Create provisions a new machine.
+func (m *MachinesAPI) Create(ctx context.Context, req CreateMachineRequest) (*CreateMachineResponse, error) {
+	var res CreateMachineResponse
+	// Machines with the default specs are created without a body.
+	var body any
+	if req != (CreateMachineRequest{}) {
+		body = req
+	}
+	if err := m.call(ctx, http.MethodPost, "", "", body, &res, false); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Delete terminates the machine.
+func (m *MachinesAPI) Delete(ctx context.Context, machineID string) error {
+	return m.call(ctx, http.MethodDelete, machineID, "", nil, nil, false)
+}
+
+// Execute runs a command with bash -c on the machine and waits for it to finish.
+func (m *MachinesAPI) Execute(ctx context.Context, machineID string, req ExecuteRequest) (*ExecuteResponse, error) {
+	var res ExecuteResponse
+	err := m.client.CallJSON(ctx, CallAPIParams{
+		Method:  http.MethodPost,
+		BaseURL: m.client.BaseURL(),
+		Path:    machinePath(machineID, "execute"),
+		Body:    req,
+		Timeout: executeTimeout,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// StartUpload returns a signed URL to upload a tar.gz archive to.
+func (m *MachinesAPI) StartUpload(ctx context.Context, machineID string) (*StartUploadResponse, error) {
+	var res StartUploadResponse
+	if err := m.call(ctx, http.MethodPost, machineID, "start_upload", nil, &res, false); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CompleteUpload extracts the uploaded archive on the machine.
+func (m *MachinesAPI) CompleteUpload(ctx context.Context, machineID string, req CompleteUploadRequest) error {
+	return m.call(ctx, http.MethodPost, machineID, "complete_upload", req, nil, false)
+}
+
+// Download archives a path on the machine and returns a signed URL to download the tar.gz from.
+func (m *MachinesAPI) Download(ctx context.Context, machineID string, req DownloadRequest) (*DownloadResponse, error) {
+	var res DownloadResponse
+	if err := m.call(ctx, http.MethodPost, machineID, "download", req, &res, true); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Screenshot captures the display and returns a signed URL to download the JPEG from.
+func (m *MachinesAPI) Screenshot(ctx context.Context, machineID string) (*ScreenshotResponse, error) {
+	var res ScreenshotResponse
+	if err := m.call(ctx, http.MethodPost, machineID, "screenshot", struct{}{}, &res, true); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Click performs a mouse click.
+func (m *MachinesAPI) Click(ctx context.Context, machineID string, req ClickRequest) error {
+	return m.call(ctx, http.MethodPost, machineID, "click", req, nil, false)
+}
+
+// Type types text, including control characters.
+func (m *MachinesAPI) Type(ctx context.Context, machineID string, req TypeRequest) error {
+	return m.call(ctx, http.MethodPost, machineID, "type", req, nil, false)
+}
+
+// Scroll scrolls up or down.
+func (m *MachinesAPI) Scroll(ctx context.Context, machineID string, req ScrollRequest) error {
+	return m.call(ctx, http.MethodPost, machineID, "scroll", req, nil, false)
+}
+
+// MouseDrag drags the mouse between two points.
+func (m *MachinesAPI) MouseDrag(ctx context.Context, machineID string, req MouseDragRequest) error {
+	return m.call(ctx, http.MethodPost, machineID, "mouse_drag", req, nil, false)
+}
+
+// OpenVNC enables VNC access and returns its credentials.
+func (m *MachinesAPI) OpenVNC(ctx context.Context, machineID string) (*OpenVNCResponse, error) {
+	var res OpenVNCResponse
+	if err := m.call(ctx, http.MethodPost, machineID, "open_vnc", struct{}{}, &res, false); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (m *MachinesAPI) call(ctx context.Context, method, machineID, action string, body, out any, idempotent bool) error {
+	return m.client.CallJSON(ctx, CallAPIParams{
+		Method:     method,
+		BaseURL:    m.client.BaseURL(),
+		Path:       machinePath(machineID, action),
+		Body:       body,
+		Idempotent: idempotent,
+	}, out)
+}
+
+// machinePath returns the path of a machine's endpoint. An empty machine ID
+// refers to the machines collection.
+func machinePath(machineID, action string) string {
+	path := "/platform/me/machines"
+	if machineID != "" {
+		path += "/" + url.PathEscape(machineID)
+	}
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
@@ -6,7 +6,29 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
+// Tool groups. Operators can enable a subset of them to limit which tools
+// are exposed to the agents.
+const (
+	GroupLifecycle = "lifecycle"
+	GroupExec      = "exec"
+	GroupTransfer  = "transfer"
+	GroupGUI       = "gui"
+	GroupVNC       = "vnc"
+)
+
+// Groups returns all tool groups.
+func Groups() []string {
+	return []string{GroupLifecycle, GroupExec, GroupTransfer, GroupGUI, GroupVNC}
+}
+
 type Tool struct {
 	Definition mcp.Tool
+	Group      string
 	Handler    func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
 }
+
+// ReadOnly reports whether the tool is annotated as not modifying its environment.
+func (t Tool) ReadOnly() bool {
+	hint := t.Definition.Annotations.ReadOnlyHint
+	return hint != nil && *hint
+}
@@ -0,0 +1,195 @@
+package fakeapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *Server) handleMe(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": map[string]string{"username": "fake-user"},
+	})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.machines))
+	for id := range s.machines {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	sort.Strings(ids)
+	writeJSON(w, http.StatusOK, map[string]any{"machine_ids": ids})
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	req := createRequest{Stack: DefaultStack, MachineType: DefaultMachineType, LifetimeMinutes: int(MachineLifetime.Minutes())}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	st, ok := findStack(req.Stack)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown stack %q", req.Stack))
+		return
+	}
+	if !isMachineType(req.MachineType) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown machine type %q", req.MachineType))
+		return
+	}
+	lifetime := time.Duration(req.LifetimeMinutes) * time.Minute
+	if lifetime <= 0 || lifetime > MaxMachineLifetime {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("lifetime must be between 1 and %d minutes", int(MaxMachineLifetime.Minutes())))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.machines) >= s.maxMachines {
+		writeError(w, http.StatusConflict, fmt.Sprintf("machine limit reached: only %d machine(s) can run at a time", s.maxMachines))
+		return
+	}
+
+	id := newID()
+	m := &Machine{
+		ID:          id,
+		RootDir:     filepath.Join(s.rootDir, id),
+		CreatedAt:   time.Now(),
+		stack:       st,
+		machineType: req.MachineType,
+		lifetime:    lifetime,
+	}
+	if err := os.MkdirAll(m.homeDir(), 0o755); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("create machine directory: %v", err))
+		return
+	}
+	if err := writeShims(m.Path(shimDir)); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("create machine shims: %v", err))
+		return
+	}
+	s.machines[id] = m
+	writeJSON(w, http.StatusCreated, map[string]string{"machine_id": id})
+}
+
+// handleGet describes the machine.
+func (s *Server) handleGet(w http.ResponseWriter, _ *http.Request, m *Machine) {
+	m.mu.Lock()
+	vncEnabled := m.vncEnabled
+	m.mu.Unlock()
+	state := s.state(m)
+	ipAddress := ""
+	if state == "ready" {
+		ipAddress = "127.0.0.1"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":           m.ID,
+		"state":        state,
+		"createdAt":    m.CreatedAt.UTC(),
+		"expiresAt":    m.CreatedAt.Add(m.lifetime).UTC(),
+		"stack":        m.stack.ID,
+		"machineType":  m.machineType,
+		"macosVersion": m.stack.MacOSVersion,
+		"xcodeVersion": m.stack.XcodeVersion,
+		"ipAddress":    ipAddress,
+		"vncEnabled":   vncEnabled,
+	})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, _ *http.Request, m *Machine) {
+	s.mu.Lock()
+	delete(s.machines, m.ID)
+	s.mu.Unlock()
+	if err := os.RemoveAll(m.RootDir); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("delete machine directory: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *Server) handleExecute(w http.ResponseWriter, r *http.Request, m *Machine) {
+	var req struct {
+		BashCCommand string `json:"bashCCommand"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	// Map absolute paths in the home directory into the machine's directory.
+	script := strings.ReplaceAll(req.BashCCommand, HomeDir, m.homeDir())
+	cmd := exec.CommandContext(r.Context(), "bash", "-c", script)
+	cmd.Dir = m.homeDir()
+	cmd.Env = append(os.Environ(), "HOME="+m.homeDir(), "PATH="+m.Path(shimDir)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("run command: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"output": output.String()})
+}
+
+func (s *Server) handleGUIEvent(action string) machineHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, m *Machine) {
+		var payload map[string]any
+		if err := readJSON(r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		m.mu.Lock()
+		m.events = append(m.events, Event{Action: action, Payload: payload})
+		m.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{})
+	}
+}
+
+func (s *Server) handleOpenVNC(w http.ResponseWriter, _ *http.Request, m *Machine) {
+	m.mu.Lock()
+	m.vncEnabled = true
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"vncAddress":  "127.0.0.1:5900",
+		"vncUsername": "vagrant",
+		"vncPassword": "vagrant",
+	})
+}
+
+// shimDir is the folder of the shims of macOS commands on the machines,
+// which comes first in the PATH of commands.
+const shimDir = "/usr/local/fakebin"
+
+// shims are the macOS commands the MCP server relies on that the local
+// system doesn't have. launchctl reports the GUI session of the user as
+// running, as it is on a booted machine.
+var shims = map[string]string{
+	"launchctl": `#!/bin/sh
+case "$1 $2" in
+"print gui/"*) echo "gui/$(id -u) = { type = Aqua }"; exit 0 ;;
+esac
+echo "launchctl: $1 is not supported on the fake machine" >&2
+exit 1
+`,
+}
+
+func writeShims(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, script := range shims {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
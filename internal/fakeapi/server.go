@@ -0,0 +1,318 @@
+// Package fakeapi implements a fake of the Bitrise /platform/me/machines API
+// for developing and testing agents without a Bitrise account.
+//
+// Every machine is simulated by a local directory that acts as the root of
+// its filesystem: the absolute path /Users/vagrant/project on the machine is
+// stored at <machine root>/Users/vagrant/project. Commands run with the local
+// bash inside the machine's home directory (/Users/vagrant), with the paths
+// starting with /Users/vagrant rewritten to it. Other absolute paths refer to
+// the local filesystem, so commands should use paths in the home directory to
+// stay inside the machine. Shims of the macOS commands the MCP server relies
+// on, like launchctl, come first in the PATH.
+package fakeapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HomeDir is the home directory of the user on the fake machines.
+const HomeDir = "/Users/vagrant"
+
+// Server is a fake Bitrise machines API. It implements http.Handler, so it
+// can be served with httptest.NewServer or http.ListenAndServe.
+type Server struct {
+	rootDir     string
+	ownsRootDir bool
+	token       string
+	maxMachines int
+	bootTime    time.Duration
+	mux         *http.ServeMux
+
+	mu       sync.Mutex
+	machines map[string]*Machine
+	uploads  map[string]*upload
+	blobs    map[string]string // signed URL token -> local file
+	// failUploads is the number of upcoming signed URL uploads to fail.
+	failUploads int
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithRootDir sets the directory the machines are created in.
+// By default a temporary directory is used, which is removed by Close.
+func WithRootDir(dir string) Option {
+	return func(s *Server) {
+		s.rootDir = dir
+	}
+}
+
+// WithToken makes the server accept only the given personal access token.
+// By default any non-empty token is accepted.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithMaxMachines sets how many machines can run at the same time (1 by default, like the real API).
+func WithMaxMachines(n int) Option {
+	return func(s *Server) {
+		s.maxMachines = n
+	}
+}
+
+// WithBootTime makes machines provision for the first half of the duration
+// and boot for the second half, like real machines do for 30-60 seconds.
+// Commands, transfers and GUI events are rejected until then. By default
+// machines are ready as soon as they are created.
+func WithBootTime(d time.Duration) Option {
+	return func(s *Server) {
+		s.bootTime = d
+	}
+}
+
+// FailUploads makes the next n uploads to signed URLs fail after reading
+// part of the body, like on a flaky network.
+func (s *Server) FailUploads(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failUploads = n
+}
+
+// Machine is a simulated remote machine.
+type Machine struct {
+	ID        string
+	RootDir   string
+	CreatedAt time.Time
+
+	// stack, machineType and lifetime are the specs the machine was created with.
+	stack       stack
+	machineType string
+	lifetime    time.Duration
+
+	mu         sync.Mutex
+	events     []Event
+	vncEnabled bool
+}
+
+// Event is a GUI interaction (click, type, scroll, mouse_drag) received by a machine.
+type Event struct {
+	Action  string
+	Payload map[string]any
+}
+
+type upload struct {
+	machineID string
+	file      string
+}
+
+// New creates a fake API server.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{
+		maxMachines: 1,
+		machines:    make(map[string]*Machine),
+		uploads:     make(map[string]*upload),
+		blobs:       make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.rootDir == "" {
+		dir, err := os.MkdirTemp("", "fake-bitrise-api-")
+		if err != nil {
+			return nil, fmt.Errorf("create root directory: %w", err)
+		}
+		s.rootDir = dir
+		s.ownsRootDir = true
+	} else if err := os.MkdirAll(s.rootDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create root directory: %w", err)
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /me", s.authenticated(s.handleMe))
+	s.mux.HandleFunc("GET /platform/me/machines", s.authenticated(s.handleList))
+	s.mux.HandleFunc("GET /platform/me/machine_specs", s.authenticated(s.handleSpecs))
+	s.mux.HandleFunc("POST /platform/me/machines", s.authenticated(s.handleCreate))
+	s.mux.HandleFunc("GET /platform/me/machines/{id}", s.authenticated(s.withMachine(s.handleGet)))
+	s.mux.HandleFunc("DELETE /platform/me/machines/{id}", s.authenticated(s.withMachine(s.handleDelete)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/execute", s.authenticated(s.withBootedMachine(s.handleExecute)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/start_upload", s.authenticated(s.withBootedMachine(s.handleStartUpload)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/complete_upload", s.authenticated(s.withBootedMachine(s.handleCompleteUpload)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/download", s.authenticated(s.withBootedMachine(s.handleDownload)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/screenshot", s.authenticated(s.withBootedMachine(s.handleScreenshot)))
+	s.mux.HandleFunc("POST /platform/me/machines/{id}/open_vnc", s.authenticated(s.withBootedMachine(s.handleOpenVNC)))
+	for _, action := range []string{"click", "type", "scroll", "mouse_drag"} {
+		s.mux.HandleFunc("POST /platform/me/machines/{id}/"+action, s.authenticated(s.withBootedMachine(s.handleGUIEvent(action))))
+	}
+	s.mux.HandleFunc("PUT /signed/upload/{token}", s.handleSignedUpload)
+	s.mux.HandleFunc("GET /signed/blob/{token}", s.handleSignedBlob)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close deletes all machines, and the root directory if it was created by the server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ownsRootDir {
+		s.machines = make(map[string]*Machine)
+		return os.RemoveAll(s.rootDir)
+	}
+	for id, m := range s.machines {
+		if err := os.RemoveAll(m.RootDir); err != nil {
+			return fmt.Errorf("delete machine %s: %w", id, err)
+		}
+		delete(s.machines, id)
+	}
+	return nil
+}
+
+// Machine returns the running machine with the given ID.
+func (s *Server) Machine(id string) (*Machine, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.machines[id]
+	return m, ok
+}
+
+// Path returns the local path of an absolute path on the machine.
+func (m *Machine) Path(remotePath string) string {
+	return resolvePath(m.RootDir, remotePath)
+}
+
+// Events returns the GUI events the machine received so far.
+func (m *Machine) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+func (m *Machine) homeDir() string {
+	return m.Path(HomeDir)
+}
+
+// resolvePath maps a path on the machine into its root directory. Relative
+// paths are relative to the home directory.
+func resolvePath(rootDir, remotePath string) string {
+	if !filepath.IsAbs(remotePath) {
+		remotePath = filepath.Join(HomeDir, remotePath)
+	}
+	// Clean as an absolute path first, so ".." can't escape the root.
+	return filepath.Join(rootDir, filepath.Clean("/"+remotePath))
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request)
+
+type machineHandlerFunc func(w http.ResponseWriter, r *http.Request, m *Machine)
+
+func (s *Server) authenticated(next handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" || (s.token != "" && token != s.token) {
+			writeError(w, http.StatusUnauthorized, "invalid or missing personal access token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// withBootedMachine rejects requests to machines that haven't booted yet,
+// with the status code of the real API.
+func (s *Server) withBootedMachine(next machineHandlerFunc) handlerFunc {
+	return s.withMachine(func(w http.ResponseWriter, r *http.Request, m *Machine) {
+		if state := s.state(m); state != "ready" {
+			writeError(w, http.StatusTooEarly, fmt.Sprintf("machine %s is not ready yet: %s", m.ID, state))
+			return
+		}
+		next(w, r, m)
+	})
+}
+
+// state returns the lifecycle state of the machine: provisioning, booting or ready.
+func (s *Server) state(m *Machine) string {
+	switch elapsed := time.Since(m.CreatedAt); {
+	case elapsed < s.bootTime/2:
+		return "provisioning"
+	case elapsed < s.bootTime:
+		return "booting"
+	default:
+		return "ready"
+	}
+}
+
+func (s *Server) withMachine(next machineHandlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, ok := s.Machine(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("machine %s not found", r.PathValue("id")))
+			return
+		}
+		next(w, r, m)
+	}
+}
+
+// signedURL registers a local file for download and returns its URL.
+func (s *Server) signedURL(r *http.Request, file string) string {
+	token := newID()
+	s.mu.Lock()
+	s.blobs[token] = file
+	s.mu.Unlock()
+	return baseURL(r) + "/signed/blob/" + token
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readJSON(r *http.Request, v any) error {
+	if r.Body == nil {
+		return nil
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// isWithinDir reports whether path is dir or inside it.
+func isWithinDir(path, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
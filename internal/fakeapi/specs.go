@@ -0,0 +1,80 @@
+package fakeapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// The default image and lifetime of the fake machines.
+const (
+	DefaultStack       = "osx-xcode-16.4.x"
+	DefaultMachineType = "g2.mac.medium"
+	MacOSVersion       = "15.5"
+	XcodeVersion       = "16.4"
+	MachineLifetime    = time.Hour
+	MaxMachineLifetime = 4 * time.Hour
+)
+
+type stack struct {
+	ID           string `json:"id"`
+	MacOSVersion string `json:"macosVersion"`
+	XcodeVersion string `json:"xcodeVersion"`
+}
+
+type machineType struct {
+	ID       string `json:"id"`
+	CPUCount int    `json:"cpuCount"`
+	RAMGB    int    `json:"ramGb"`
+}
+
+// stacks are the images machines can be created with. The stacks of fake
+// machines only differ in the versions they report.
+var stacks = []stack{
+	{ID: DefaultStack, MacOSVersion: MacOSVersion, XcodeVersion: XcodeVersion},
+	{ID: "osx-xcode-16.2.x", MacOSVersion: "15.3", XcodeVersion: "16.2"},
+	{ID: "osx-xcode-15.4.x", MacOSVersion: "14.5", XcodeVersion: "15.4"},
+}
+
+// machineTypes are the hardware machines can be created with. Fake machines
+// only report them.
+var machineTypes = []machineType{
+	{ID: DefaultMachineType, CPUCount: 4, RAMGB: 12},
+	{ID: "g2.mac.large", CPUCount: 8, RAMGB: 24},
+	{ID: "g2.mac.x-large", CPUCount: 12, RAMGB: 48},
+}
+
+func (s *Server) handleSpecs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"stacks":                 stacks,
+		"machineTypes":           machineTypes,
+		"defaultStack":           DefaultStack,
+		"defaultMachineType":     DefaultMachineType,
+		"defaultLifetimeMinutes": int(MachineLifetime.Minutes()),
+		"maxLifetimeMinutes":     int(MaxMachineLifetime.Minutes()),
+	})
+}
+
+// createRequest selects the specs of a new machine. The defaults are used for the empty fields.
+type createRequest struct {
+	Stack           string `json:"stack"`
+	MachineType     string `json:"machineType"`
+	LifetimeMinutes int    `json:"lifetimeMinutes"`
+}
+
+func findStack(id string) (stack, bool) {
+	for _, st := range stacks {
+		if st.ID == id {
+			return st, true
+		}
+	}
+	return stack{}, false
+}
+
+func isMachineType(id string) bool {
+	for _, mt := range machineTypes {
+		if mt.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package fakeapi
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	screenWidth  = 1024
+	screenHeight = 768
+)
+
+func (s *Server) handleStartUpload(w http.ResponseWriter, r *http.Request, m *Machine) {
+	file, err := os.CreateTemp(s.rootDir, "upload-*.tar.gz")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("create upload file: %v", err))
+		return
+	}
+	file.Close()
+
+	uploadID := newID()
+	s.mu.Lock()
+	s.uploads[uploadID] = &upload{machineID: m.ID, file: file.Name()}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"signedUrl": baseURL(r) + "/signed/upload/" + uploadID,
+		"uploadId":  uploadID,
+	})
+}
+
+func (s *Server) handleSignedUpload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	up, ok := s.uploads[r.PathValue("token")]
+	fail := s.failUploads > 0
+	if fail {
+		s.failUploads--
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusForbidden, "invalid signed URL")
+		return
+	}
+	// Like the storage behind the real signed URLs, chunked uploads are rejected.
+	if r.ContentLength < 0 {
+		writeError(w, http.StatusLengthRequired, "missing Content-Length")
+		return
+	}
+	if fail {
+		_, _ = io.CopyN(io.Discard, r.Body, 1024)
+		writeError(w, http.StatusServiceUnavailable, "connection reset")
+		return
+	}
+
+	file, err := os.Create(up.file)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("open upload file: %v", err))
+		return
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, r.Body); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("store upload: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request, m *Machine) {
+	var req struct {
+		UploadID                string `json:"uploadId"`
+		DestinationParentFolder string `json:"destinationParentFolder"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	up, ok := s.uploads[req.UploadID]
+	if ok && up.machineID == m.ID {
+		delete(s.uploads, req.UploadID)
+	}
+	s.mu.Unlock()
+	if !ok || up.machineID != m.ID {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("upload %s not found", req.UploadID))
+		return
+	}
+	defer os.Remove(up.file)
+
+	if err := extractTarGz(up.file, m.Path(req.DestinationParentFolder)); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("extract upload: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, m *Machine) {
+	var req struct {
+		SourcePath           string `json:"sourcePath"`
+		OnlyContentsOfFolder bool   `json:"onlyContentsOfFolder"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	source := m.Path(req.SourcePath)
+	if _, err := os.Lstat(source); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("source path %s not found", req.SourcePath))
+		return
+	}
+	file, err := os.CreateTemp(s.rootDir, "download-*.tar.gz")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("create download file: %v", err))
+		return
+	}
+	defer file.Close()
+	if err := createTarGz(file, source, req.OnlyContentsOfFolder); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("archive source path: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"signedUrl": s.signedURL(r, file.Name())})
+}
+
+func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request, m *Machine) {
+	file, err := os.CreateTemp(s.rootDir, "screenshot-*.jpg")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("create screenshot file: %v", err))
+		return
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, screenshot(len(m.Events())), nil); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("encode screenshot: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"signedUrl": s.signedURL(r, file.Name())})
+}
+
+func (s *Server) handleSignedBlob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	file, ok := s.blobs[r.PathValue("token")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusForbidden, "invalid signed URL")
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// screenshot generates a gradient image, which changes with every GUI event
+// the machine receives.
+func screenshot(seed int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, screenWidth, screenHeight))
+	for y := range screenHeight {
+		for x := range screenWidth {
+			img.Set(x, y, color.RGBA{
+				R: uint8(x * 255 / screenWidth),
+				G: uint8(y * 255 / screenHeight),
+				B: uint8(seed * 40),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// createTarGz archives source like "tar -czf - -C <parent> <name>" does, or
+// only the contents of the folder if onlyContents is true.
+func createTarGz(w io.Writer, source string, onlyContents bool) error {
+	gzWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	base := filepath.Dir(source)
+	if onlyContents {
+		base = source
+	}
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzWriter.Close()
+}
+
+// extractTarGz extracts the archive into dest, like "tar -xzf <archive> -C <dest>".
+func extractTarGz(archive, dest string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, filepath.Clean("/"+header.Name))
+		if !isWithinDir(target, dest) {
+			return fmt.Errorf("path traversal detected: %s", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeFile(tarReader, target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(r io.Reader, path string, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, r)
+	return err
+}
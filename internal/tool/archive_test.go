@@ -0,0 +1,251 @@
+package tool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "project")
+	writeFiles(t, src, map[string]string{
+		"a.txt":         "a",
+		"sub/b.txt":     "b",
+		"sub/deep/c.sh": "#!/bin/sh\n",
+		"._a.txt":       "apple double",
+	})
+	if err := os.Chmod(filepath.Join(src, "sub/deep/c.sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/b.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		source       string
+		onlyContents bool
+		want         []string
+	}{
+		{
+			name:   "folder",
+			source: src,
+			want:   []string{"project", "project/a.txt", "project/link", "project/sub", "project/sub/b.txt", "project/sub/deep", "project/sub/deep/c.sh"},
+		},
+		{
+			name:         "only contents of folder",
+			source:       src,
+			onlyContents: true,
+			want:         []string{"a.txt", "link", "sub", "sub/b.txt", "sub/deep", "sub/deep/c.sh"},
+		},
+		{
+			name:   "single file",
+			source: filepath.Join(src, "sub/b.txt"),
+			want:   []string{"b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mustCreateTarGz(t, tt.source, tt.onlyContents)
+			dest := t.TempDir()
+			paths, err := (&extraction{}).extractTarGz(bytes.NewReader(data), dest)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+
+			var got []string
+			for _, path := range paths {
+				rel, err := filepath.Rel(dest, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("extracted %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("content, modes and symlinks are preserved", func(t *testing.T) {
+		dest := t.TempDir()
+		if _, err := (&extraction{}).extractTarGz(bytes.NewReader(mustCreateTarGz(t, src, false)), dest); err != nil {
+			t.Fatalf("extract: %v", err)
+		}
+		assertFile(t, filepath.Join(dest, "project/link"), "b")
+		info, err := os.Stat(filepath.Join(dest, "project/sub/deep/c.sh"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&0o111 == 0 {
+			t.Errorf("executable bit lost: %v", info.Mode())
+		}
+		target, err := os.Readlink(filepath.Join(dest, "project/link"))
+		if err != nil || target != "sub/b.txt" {
+			t.Errorf("symlink: got %q (%v), want sub/b.txt", target, err)
+		}
+	})
+}
+
+func TestUploadFilter(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "project")
+	writeFiles(t, src, map[string]string{
+		".git/HEAD":                  "ref: refs/heads/main\n",
+		".gitignore":                 "*.log\n/build/\n!keep.log\n",
+		".remotemachineignore":       "Pods/\n",
+		"app.log":                    "log",
+		"keep.log":                   "keep",
+		"build/out.o":                "12345",
+		"Pods/Lib/lib.swift":         "pod",
+		"Sources/main.swift":         "main",
+		"Sources/.gitignore":         "generated.swift\n",
+		"Sources/generated.swift":    "gen",
+		"Sources/sub/build/notes.md": "not ignored, /build/ is anchored",
+		"DerivedData/Index/x.idx":    "idx",
+	})
+
+	tests := []struct {
+		name             string
+		include, exclude []string
+		gitignore        bool
+		want             []string
+		wantSkipped      int
+	}{
+		{
+			name:        "remotemachineignore is always honored",
+			want:        []string{".git/HEAD", ".gitignore", ".remotemachineignore", "DerivedData/Index/x.idx", "Sources/.gitignore", "Sources/generated.swift", "Sources/main.swift", "Sources/sub/build/notes.md", "app.log", "build/out.o", "keep.log"},
+			wantSkipped: 1,
+		},
+		{
+			name:        "gitignore at every level",
+			gitignore:   true,
+			exclude:     []string{"DerivedData"},
+			want:        []string{".gitignore", ".remotemachineignore", "Sources/.gitignore", "Sources/main.swift", "Sources/sub/build/notes.md", "keep.log"},
+			wantSkipped: 6,
+		},
+		{
+			name:        "include and exclude globs",
+			include:     []string{"Sources/**/*.swift", "*.log"},
+			exclude:     []string{"generated.swift"},
+			want:        []string{"Sources/main.swift", "app.log", "keep.log"},
+			wantSkipped: 9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newUploadFilter(tt.include, tt.exclude, tt.gitignore)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Lstat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			stats, err := createTarGz(&buf, src, info, true, filter, nil)
+			if err != nil {
+				t.Fatalf("create archive: %v", err)
+			}
+			dest := t.TempDir()
+			paths, err := (&extraction{}).extractTarGz(bytes.NewReader(buf.Bytes()), dest)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+
+			var got []string
+			for _, path := range paths {
+				if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+					rel, _ := filepath.Rel(dest, path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("uploaded %v, want %v", got, tt.want)
+			}
+			if stats.files != len(tt.want) || stats.skippedFiles != tt.wantSkipped {
+				t.Errorf("got %d files and %d skipped, want %d and %d", stats.files, stats.skippedFiles, len(tt.want), tt.wantSkipped)
+			}
+		})
+	}
+
+	if _, err := newUploadFilter(nil, []string{"[abc"}, false); err == nil {
+		t.Error("invalid glob was accepted")
+	}
+}
+
+func TestUploadToSignedURL(t *testing.T) {
+	var (
+		body          []byte
+		contentLength int64
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	// The body is a plain reader, so the length must come from the size argument.
+	content := strings.Repeat("0123456789", 1<<16)
+	if err := uploadToSignedURL(context.Background(), ts.URL, io.MultiReader(strings.NewReader(content)), int64(len(content))); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if contentLength != int64(len(content)) {
+		t.Errorf("got content length %d, want %d", contentLength, len(content))
+	}
+	if string(body) != content {
+		t.Errorf("got %d bytes, want %d", len(body), len(content))
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer failing.Close()
+	err := uploadToSignedURL(context.Background(), failing.URL, strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("got %v, want upload error", err)
+	}
+}
+
+func mustCreateTarGz(t *testing.T, source string, onlyContents bool) []byte {
+	t.Helper()
+	info, err := os.Lstat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := createTarGz(&buf, source, info, onlyContents, nil, nil); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// tarGz builds an archive from raw headers with empty contents.
+func tarGz(t *testing.T, headers ...tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -1,22 +1,234 @@
 package tool
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
 type Belt struct {
-	tools map[string]bitrise.Tool
+	tools    map[string]bitrise.Tool
+	readOnly bool
+	output   outputSettings
+	transfer transferSettings
+	ready    readySettings
+	// specs is the configured catalog of machine specs. It is fetched from the API if nil.
+	specs *bitrise.MachineSpecs
+
+	subscriptions *resourceSubscriptions
+}
+
+// outputSettings control how much of the output of commands is returned to
+// the agents, and where the full output is kept.
+type outputSettings struct {
+	headBytes int
+	tailBytes int
+	log       *commandLog
+}
+
+// Default output limits, applied to stdout and stderr each.
+const (
+	DefaultOutputHeadBytes = 8 * 1024
+	DefaultOutputTailBytes = 8 * 1024
+)
+
+// maxCapturedOutputBytes bounds the output of a command fetched from the
+// remote machine and saved, per stream.
+const maxCapturedOutputBytes = 16 << 20
+
+func defaultOutputSettings() outputSettings {
+	return outputSettings{
+		headBytes: DefaultOutputHeadBytes,
+		tailBytes: DefaultOutputTailBytes,
+		log:       &commandLog{dir: defaultCommandLogDir()},
+	}
+}
+
+type outputSettingsKey struct{}
+
+func outputSettingsFromContext(ctx context.Context) outputSettings {
+	if settings, ok := ctx.Value(outputSettingsKey{}).(outputSettings); ok {
+		return settings
+	}
+	return defaultOutputSettings()
+}
+
+// transferSettings control file transfers.
+type transferSettings struct {
+	// partBytes is the size of the parts uploads are split into.
+	partBytes int
+	manifests *syncManifestStore
+	// extract limits what a downloaded archive may extract.
+	extract extractLimits
+}
+
+// DefaultUploadPartBytes is the default size of the parts uploads are split into.
+const DefaultUploadPartBytes = 32 << 20
+
+// The default limits of downloads. They are far above real build artifacts,
+// and only stop archives that would fill the disk.
+const (
+	DefaultDownloadMaxBytes     = 20 << 30
+	DefaultDownloadMaxFiles     = 1_000_000
+	DefaultDownloadMaxFileBytes = 10 << 30
+)
+
+func defaultTransferSettings() transferSettings {
+	return transferSettings{
+		partBytes: DefaultUploadPartBytes,
+		manifests: &syncManifestStore{dir: defaultSyncManifestDir()},
+		extract: extractLimits{
+			maxBytes:     DefaultDownloadMaxBytes,
+			maxFiles:     DefaultDownloadMaxFiles,
+			maxFileBytes: DefaultDownloadMaxFileBytes,
+		},
+	}
 }
 
-func NewBelt() *Belt {
+type transferSettingsKey struct{}
+
+func transferSettingsFromContext(ctx context.Context) transferSettings {
+	if settings, ok := ctx.Value(transferSettingsKey{}).(transferSettings); ok {
+		return settings
+	}
+	return defaultTransferSettings()
+}
+
+// readySettings control how machines are polled while waiting for them to boot.
+type readySettings struct {
+	// minInterval is the first interval between the checks, which doubles up to maxInterval.
+	minInterval time.Duration
+	maxInterval time.Duration
+}
+
+// The default intervals between the readiness checks of booting machines.
+const (
+	DefaultReadyPollMinInterval = 2 * time.Second
+	DefaultReadyPollMaxInterval = 15 * time.Second
+)
+
+func defaultReadySettings() readySettings {
+	return readySettings{minInterval: DefaultReadyPollMinInterval, maxInterval: DefaultReadyPollMaxInterval}
+}
+
+type readySettingsKey struct{}
+
+func readySettingsFromContext(ctx context.Context) readySettings {
+	if settings, ok := ctx.Value(readySettingsKey{}).(readySettings); ok {
+		return settings
+	}
+	return defaultReadySettings()
+}
+
+// BeltOption configures a Belt.
+type BeltOption func(*Belt)
+
+// WithReadOnly restricts the belt to tools that don't modify the remote machine.
+func WithReadOnly() BeltOption {
+	return func(b *Belt) {
+		b.readOnly = true
+	}
+}
+
+// WithOutputLimit sets how many bytes of the beginning and of the end of
+// stdout and stderr of commands are returned. The rest is replaced by a marker.
+func WithOutputLimit(headBytes, tailBytes int) BeltOption {
+	return func(b *Belt) {
+		b.output.headBytes = headBytes
+		b.output.tailBytes = tailBytes
+	}
+}
+
+// WithCommandLogDir sets the local directory the full output of commands is saved in.
+func WithCommandLogDir(dir string) BeltOption {
+	return func(b *Belt) {
+		b.output.log = &commandLog{dir: dir}
+	}
+}
+
+// WithSyncManifestDir sets the local directory the manifests of synced folders are kept in.
+func WithSyncManifestDir(dir string) BeltOption {
+	return func(b *Belt) {
+		b.transfer.manifests = &syncManifestStore{dir: dir}
+	}
+}
+
+// WithUploadPartSize sets the size of the parts uploads are split into. A
+// failed part is retried on its own, so smaller parts lose less progress on
+// flaky networks, but need more API calls. Sizes below 1 are ignored.
+func WithUploadPartSize(bytes int) BeltOption {
+	return func(b *Belt) {
+		if bytes > 0 {
+			b.transfer.partBytes = bytes
+		}
+	}
+}
+
+// WithDownloadLimits sets the maximum number of bytes and files a download
+// may extract in total, and the maximum size of a single file. A download
+// exceeding them is aborted and rolled back. Limits below 1 disable the given
+// check.
+func WithDownloadLimits(maxBytes int64, maxFiles int, maxFileBytes int64) BeltOption {
+	return func(b *Belt) {
+		b.transfer.extract = extractLimits{maxBytes: maxBytes, maxFiles: maxFiles, maxFileBytes: maxFileBytes}
+	}
+}
+
+// WithReadyPolling sets the intervals between the readiness checks of booting
+// machines: the first interval, which doubles after every check up to the
+// maximum. Intervals below 1 are ignored.
+func WithReadyPolling(minInterval, maxInterval time.Duration) BeltOption {
+	return func(b *Belt) {
+		if minInterval > 0 && maxInterval >= minInterval {
+			b.ready = readySettings{minInterval: minInterval, maxInterval: maxInterval}
+		}
+	}
+}
+
+// WithMachineSpecs sets the catalog of the stacks and machine types machines
+// can be created with, instead of fetching it from the API.
+func WithMachineSpecs(specs *bitrise.MachineSpecs) BeltOption {
+	return func(b *Belt) {
+		b.specs = specs
+	}
+}
+
+// WithResourcePollInterval sets how often the files sessions subscribed to
+// are checked for changes. Intervals below 1 are ignored.
+func WithResourcePollInterval(interval time.Duration) BeltOption {
+	return func(b *Belt) {
+		if interval > 0 {
+			b.subscriptions.interval = interval
+		}
+	}
+}
+
+func NewBelt(opts ...BeltOption) *Belt {
 	var toolList = []bitrise.Tool{
 		ListRemoteMachines,
+		DescribeRemoteMachine,
+		ListMachineSpecs,
 		CreateRemoteMachine,
+		WaitForReady,
 		DeleteRemoteMachine,
 		ExecuteCommand,
+		CommandOutput,
+		JobStart,
+		JobStatus,
+		JobOutput,
+		JobCancel,
 		Upload,
+		Sync,
 		Download,
+		ReadFile,
+		WriteFile,
+		StatFile,
+		ListDir,
 		OpenVNC,
 		Click,
 		MouseDrag,
@@ -24,15 +236,146 @@ func NewBelt() *Belt {
 		Scroll,
 		Type,
 	}
-	belt := &Belt{tools: make(map[string]bitrise.Tool)}
+	belt := &Belt{
+		tools:    make(map[string]bitrise.Tool),
+		output:   defaultOutputSettings(),
+		transfer: defaultTransferSettings(),
+		ready:    defaultReadySettings(),
+		subscriptions: &resourceSubscriptions{
+			interval: DefaultResourcePollInterval,
+			sessions: make(map[string]bool),
+			watches:  make(map[string]map[string]context.CancelFunc),
+		},
+	}
 	for _, tool := range toolList {
 		belt.tools[tool.Definition.Name] = tool
 	}
+	for _, opt := range opts {
+		opt(belt)
+	}
 	return belt
 }
 
+// RegisterAll registers every tool, except the mutating ones in read-only
+// mode, and the remote file resources.
 func (b *Belt) RegisterAll(server *server.MCPServer) {
 	for _, tool := range b.tools {
+		if b.readOnly && !tool.ReadOnly() {
+			continue
+		}
 		server.AddTool(tool.Definition, tool.Handler)
 	}
+	b.registerResources(server)
+}
+
+// RegisterGroups registers the tools of the given groups only.
+// All tools are registered if no group is given.
+func (b *Belt) RegisterGroups(server *server.MCPServer, groups []string) error {
+	if len(groups) == 0 {
+		b.RegisterAll(server)
+		return nil
+	}
+	for _, group := range groups {
+		if !slices.Contains(bitrise.Groups(), group) {
+			return fmt.Errorf("unknown tool group %q (available groups: %v)", group, bitrise.Groups())
+		}
+	}
+	for _, tool := range b.tools {
+		if b.readOnly && !tool.ReadOnly() {
+			continue
+		}
+		if slices.Contains(groups, tool.Group) {
+			server.AddTool(tool.Definition, tool.Handler)
+		}
+	}
+	// The remote file resources belong to the transfer group.
+	if slices.Contains(groups, bitrise.GroupTransfer) {
+		b.registerResources(server)
+	}
+	return nil
+}
+
+func (b *Belt) registerResources(server *server.MCPServer) {
+	server.AddResourceTemplates(RemoteFiles)
+	b.subscriptions.server = server
+}
+
+// RegisterHooks keeps track of the sessions, so the resource subscriptions of
+// a session are cancelled when it ends.
+func (b *Belt) RegisterHooks(hooks *server.Hooks) {
+	hooks.AddOnRegisterSession(func(_ context.Context, session server.ClientSession) {
+		b.subscriptions.sessionStarted(session.SessionID())
+	})
+	hooks.AddOnUnregisterSession(func(_ context.Context, session server.ClientSession) {
+		b.subscriptions.sessionEnded(session.SessionID())
+	})
+}
+
+// ToolFilter hides the tools whose group is not enabled for the session.
+func (b *Belt) ToolFilter(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	if _, ok := bitrise.EnabledGroupsFromContext(ctx); !ok {
+		return tools
+	}
+	filtered := make([]mcp.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if b.enabled(ctx, tool.Name) {
+			filtered = append(filtered, tool)
+		}
+	}
+	return filtered
+}
+
+// Middleware rejects calls to tools whose group is not enabled for the session,
+// and calls to mutating tools in read-only mode. It also makes the belt's
+// settings available to the tool handlers.
+func (b *Belt) Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if tool, ok := b.tools[request.Params.Name]; b.readOnly && (!ok || !tool.ReadOnly()) {
+			return mcp.NewToolResultError(fmt.Sprintf(
+				"tool %s is not available: the server runs in read-only mode", request.Params.Name,
+			)), nil
+		}
+		if !b.enabled(ctx, request.Params.Name) {
+			return mcp.NewToolResultError(fmt.Sprintf(
+				"tool %s is not available: its group %q is not enabled for this session",
+				request.Params.Name, b.tools[request.Params.Name].Group,
+			)), nil
+		}
+		ctx = context.WithValue(ctx, outputSettingsKey{}, b.output)
+		ctx = context.WithValue(ctx, transferSettingsKey{}, b.transfer)
+		ctx = context.WithValue(ctx, readySettingsKey{}, b.ready)
+		if b.specs != nil {
+			ctx = context.WithValue(ctx, machineSpecsKey{}, b.specs)
+		}
+		return next(ctx, request)
+	}
+}
+
+// ResourceMiddleware rejects reads of the remote file resources if the
+// transfer group is not enabled for the session.
+func (b *Belt) ResourceMiddleware(next server.ResourceHandlerFunc) server.ResourceHandlerFunc {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		if !b.groupEnabled(ctx, bitrise.GroupTransfer) {
+			return nil, fmt.Errorf("resource %s is not available: the %q group is not enabled for this session",
+				request.Params.URI, bitrise.GroupTransfer)
+		}
+		return next(ctx, request)
+	}
+}
+
+func (b *Belt) groupEnabled(ctx context.Context, group string) bool {
+	groups, ok := bitrise.EnabledGroupsFromContext(ctx)
+	return !ok || slices.Contains(groups, group)
+}
+
+func (b *Belt) enabled(ctx context.Context, name string) bool {
+	groups, ok := bitrise.EnabledGroupsFromContext(ctx)
+	if !ok {
+		return true
+	}
+	tool, ok := b.tools[name]
+	if !ok {
+		return true
+	}
+	return slices.Contains(groups, tool.Group)
 }
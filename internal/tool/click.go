@@ -2,8 +2,6 @@ package tool
 
 import (
 	"context"
-	"fmt"
-	"net/http"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -49,6 +47,8 @@ Use this tool in combination with bitrise_remote_machine_screenshot to identify
 and verify click results. Coordinates are relative to the screen's top-left corner (0,0).
 The screen resolution is 1024x768.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to perform the click on"),
 			mcp.Required(),
@@ -69,6 +69,7 @@ The screen resolution is 1024x768.`,
 			mcp.Description("Whether to perform a double click"),
 		),
 	),
+	Group: bitrise.GroupGUI,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -90,26 +91,15 @@ The screen resolution is 1024x768.`,
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{
-			"x":      int(x),
-			"y":      int(y),
-			"button": button,
-		}
-
-		doubleClick := request.GetBool("double_click", false)
-		if doubleClick {
-			body["doubleClick"] = doubleClick
-		}
-
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/click", machineID),
-			Body:    body,
+		err = bitrise.Machines(ctx).Click(ctx, machineID, bitrise.ClickRequest{
+			X:           int(x),
+			Y:           int(y),
+			Button:      button,
+			DoubleClick: request.GetBool("double_click", false),
 		})
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to perform click", err), nil
+			return newToolResultAPIError("failed to perform click", err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultText(""), nil
 	},
 }
@@ -0,0 +1,214 @@
+package tool
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+)
+
+// commandLogRetention is how long the output of commands is kept.
+const commandLogRetention = 24 * time.Hour
+
+var commandIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+var errCommandLogNotFound = errors.New("command output not found")
+
+// commandLog persists the full output of executed commands in a local
+// directory, so it can be paged through and searched after the truncated
+// result was returned.
+type commandLog struct {
+	dir string
+}
+
+// commandLogMeta describes a saved command.
+type commandLogMeta struct {
+	MachineID string    `json:"machine_id"`
+	Command   string    `json:"command"`
+	ExitCode  int       `json:"exit_code"`
+	CreatedAt time.Time `json:"created_at"`
+	// Owner is a hash of the Bitrise token the command was executed with,
+	// so tenants of a shared server can only read their own output.
+	Owner string `json:"owner"`
+}
+
+// defaultCommandLogDir returns the directory used if no other one is configured.
+func defaultCommandLogDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "bitrise-mcp", "commands")
+}
+
+// save stores the output of a command and returns its ID.
+func (l *commandLog) save(ctx context.Context, machineID, command string, res *bitrise.CommandResult) (string, error) {
+	l.removeExpired()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate command ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+	dir := filepath.Join(l.dir, id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create command log directory: %w", err)
+	}
+
+	meta, err := json.Marshal(commandLogMeta{
+		MachineID: machineID,
+		Command:   command,
+		ExitCode:  res.ExitCode,
+		CreatedAt: time.Now().UTC(),
+		Owner:     ownerOf(ctx),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal command log metadata: %w", err)
+	}
+	files := map[string][]byte{
+		"meta.json":  meta,
+		"stdout.log": []byte(res.Stdout),
+		"stderr.log": []byte(res.Stderr),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			return "", fmt.Errorf("write command log: %w", err)
+		}
+	}
+	return id, nil
+}
+
+// open opens a stream ("stdout" or "stderr") of a saved command.
+func (l *commandLog) open(ctx context.Context, id, stream string) (*os.File, error) {
+	if !commandIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: invalid command ID %q", errCommandLogNotFound, id)
+	}
+	if stream != "stdout" && stream != "stderr" {
+		return nil, fmt.Errorf("invalid stream %q: must be stdout or stderr", stream)
+	}
+	dir := filepath.Join(l.dir, id)
+
+	data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s (the output of commands is kept for %s)", errCommandLogNotFound, id, commandLogRetention)
+	} else if err != nil {
+		return nil, fmt.Errorf("read command log metadata: %w", err)
+	}
+	var meta commandLogMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parse command log metadata: %w", err)
+	}
+	if meta.Owner != ownerOf(ctx) {
+		return nil, fmt.Errorf("%w: %s", errCommandLogNotFound, id)
+	}
+	return os.Open(filepath.Join(dir, stream+".log"))
+}
+
+// removeExpired deletes the output of commands older than the retention period.
+func (l *commandLog) removeExpired() {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !commandIDPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if time.Since(info.ModTime()) > commandLogRetention {
+			_ = os.RemoveAll(filepath.Join(l.dir, entry.Name()))
+		}
+	}
+}
+
+func ownerOf(ctx context.Context) string {
+	sum := sha256.Sum256([]byte(bitrise.PATFromContext(ctx)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// outputLine is a numbered line of command output.
+type outputLine struct {
+	Number int    `json:"number" jsonschema:"description=Line number\\, starting at 1"`
+	Text   string `json:"text" jsonschema:"description=Content of the line"`
+}
+
+// maxLineLength bounds the length of a single returned line.
+const maxLineLength = 4096
+
+// readLines returns up to maxLines lines from startLine (1-based) that match
+// pattern (all lines if nil), the total number of lines, and the line to
+// continue from (0 if the end was reached).
+func readLines(r io.Reader, startLine, maxLines int, pattern *regexp.Regexp) ([]outputLine, int, int, error) {
+	var lines []outputLine
+	total, next := 0, 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if errors.Is(err, io.EOF) {
+				return lines, total, next, nil
+			}
+			return nil, 0, 0, fmt.Errorf("read command output: %w", err)
+		}
+		total++
+		if total < startLine || next != 0 {
+			continue
+		}
+		line = trimNewline(line)
+		if pattern != nil && !pattern.MatchString(line) {
+			continue
+		}
+		if len(lines) == maxLines {
+			next = total
+			continue
+		}
+		if len(line) > maxLineLength {
+			line = line[:maxLineLength] + fmt.Sprintf(" [... %d more bytes]", len(line)-maxLineLength)
+		}
+		lines = append(lines, outputLine{Number: total, Text: line})
+	}
+}
+
+func trimNewline(line string) string {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+	}
+	return line
+}
+
+// truncateMiddle keeps the first head and the last tail bytes of s, and
+// replaces the rest with a marker that tells how to get the omitted output.
+func truncateMiddle(s string, head, tail int, commandID string) (string, bool) {
+	if len(s) <= head+tail {
+		return s, false
+	}
+	// Cut at rune boundaries, so the result stays valid UTF-8.
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	start := len(s) - tail
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	omitted := start - head
+	hint := "the full output was not saved"
+	if commandID != "" {
+		hint = fmt.Sprintf("page through or search the full output with bitrise_remote_machine_command_output(command_id=%q)", commandID)
+	}
+	return fmt.Sprintf("%s\n[... %d bytes omitted; %s ...]\n%s", s[:head], omitted, hint, s[start:]), true
+}
@@ -0,0 +1,122 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultOutputLines is how many lines bitrise_remote_machine_command_output returns by default.
+const defaultOutputLines = 200
+
+var CommandOutput = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_command_output",
+		mcp.WithDescription(
+			`Page through or search the full output of a command run with bitrise_remote_machine_execute.
+
+PURPOSE:
+bitrise_remote_machine_execute only returns the beginning and the end of long output, and saves the full
+output under the returned command_id. Use this tool to read the omitted part, or to find the relevant lines
+(e.g. compiler errors or failing tests) with a pattern, without running the command again.
+
+PARAMETERS:
+- command_id (required): The command_id returned by bitrise_remote_machine_execute.
+- stream (optional): "stdout" (default) or "stderr".
+- start_line (optional): The line number to start from, starting at 1 (default).
+- max_lines (optional): The maximum number of lines to return. Defaults to 200.
+- pattern (optional): A regular expression (RE2 syntax). Only the lines matching it are returned, like grep.
+
+EXAMPLES:
+- Read the next page: bitrise_remote_machine_command_output(command_id="3f2a...", start_line=201)
+- Find build errors: bitrise_remote_machine_command_output(command_id="3f2a...", pattern="error:|warning:")
+- Find failing tests: bitrise_remote_machine_command_output(command_id="3f2a...", pattern="Test Case .* failed")
+
+IMPORTANT NOTES:
+- The output is kept for 24 hours on the computer the MCP server runs on.
+- Secrets passed in the env parameter of bitrise_remote_machine_execute are masked in the saved output too.
+- Lines longer than 4096 bytes are cut.
+
+RETURNS: The command_id, the stream, total_lines (the number of lines of the stream), the lines (each with
+its number and text), and next_line: the start_line to continue from, or 0 if there are no more lines.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[commandOutputResult](),
+		mcp.WithString("command_id",
+			mcp.Description("The ID of the command returned by bitrise_remote_machine_execute"),
+			mcp.Required(),
+		),
+		mcp.WithString("stream",
+			mcp.Description("The output stream to read"),
+			mcp.Enum("stdout", "stderr"),
+		),
+		mcp.WithNumber("start_line",
+			mcp.Description("The line number to start from, starting at 1"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_lines",
+			mcp.Description("The maximum number of lines to return"),
+			mcp.Min(1),
+		),
+		mcp.WithString("pattern",
+			mcp.Description("A regular expression (RE2 syntax) to return only the matching lines, like grep"),
+		),
+	),
+	Group: bitrise.GroupExec,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		commandID, err := request.RequireString("command_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		var pattern *regexp.Regexp
+		if expr := request.GetString("pattern", ""); expr != "" {
+			if pattern, err = regexp.Compile(expr); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid pattern: %v", err)), nil
+			}
+		}
+
+		stream := request.GetString("stream", "stdout")
+		file, err := outputSettingsFromContext(ctx).log.open(ctx, commandID, stream)
+		if errors.Is(err, errCommandLogNotFound) {
+			return mcp.NewToolResultError(fmt.Sprintf(
+				"%v. Check the command_id returned by bitrise_remote_machine_execute, or run the command again.", err)), nil
+		} else if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to open command output", err), nil
+		}
+		defer file.Close()
+
+		lines, total, next, err := readLines(file,
+			max(request.GetInt("start_line", 1), 1),
+			max(request.GetInt("max_lines", defaultOutputLines), 1),
+			pattern,
+		)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to read command output", err), nil
+		}
+		if lines == nil {
+			lines = []outputLine{}
+		}
+		return mcp.NewToolResultStructuredOnly(commandOutputResult{
+			CommandID:  commandID,
+			Stream:     stream,
+			TotalLines: total,
+			Lines:      lines,
+			NextLine:   next,
+		}), nil
+	},
+}
+
+// commandOutputResult is the result of bitrise_remote_machine_command_output.
+type commandOutputResult struct {
+	CommandID  string       `json:"command_id" jsonschema:"description=ID of the command"`
+	Stream     string       `json:"stream" jsonschema:"enum=stdout,enum=stderr,description=The stream the lines are from"`
+	TotalLines int          `json:"total_lines" jsonschema:"description=Number of lines of the stream"`
+	Lines      []outputLine `json:"lines" jsonschema:"description=The returned lines"`
+	NextLine   int          `json:"next_line" jsonschema:"description=start_line to continue from\\, 0 if there are no more lines"`
+}
@@ -2,7 +2,7 @@ package tool
 
 import (
 	"context"
-	"net/http"
+	"fmt"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -22,8 +22,9 @@ IMPORTANT CONSTRAINTS:
 LIFECYCLE INFORMATION:
 - The returned machine_id is required for ALL subsequent operations (execute, upload, download, delete).
 - The VM will appear in bitrise_remote_machine_list immediately after creation, but it needs time to boot up.
-- The first bitrise_remote_machine_execute call may take longer as it waits for the VM to become ready.
-- VMs will automatically expire and terminate after 1 hour if not manually deleted.
+- Commands, file transfers and GUI tools can't be used until the VM has booted. Set wait to true, or call
+  bitrise_remote_machine_wait_for_ready, to wait for it.
+- VMs will automatically expire and terminate after 1 hour (or lifetime_minutes) if not manually deleted.
 - ALWAYS store the machine_id and reuse the same VM for related tasks to avoid unnecessary provisioning time.
 
 WHEN TO CREATE A NEW VM:
@@ -37,18 +38,109 @@ BEST PRACTICES:
 - If the user might have follow-up tasks, ask before deleting the VM.
 - Remember the 1-hour expiration: for long-running tasks, be aware of elapsed time.
 
-RETURNS: A JSON object containing 'machine_id' (string) - save this for all subsequent operations.`,
+PARAMETERS:
+All of them are optional. Without specs the VM is created with the default stack and machine type listed by
+bitrise_remote_machine_list_specs.
+- stack: The ID of the stack (image) to create the VM with.
+- xcode_version, macos_version: Select the newest stack with these versions instead. "15" matches 15.x.
+- machine_type: The ID of the machine type (hardware) to create the VM with.
+- cpu_count, ram_gb: Select the smallest machine type with at least this many CPU cores and GB of RAM instead.
+- lifetime_minutes: How long the VM lives before it is deleted automatically, up to the max_lifetime_minutes
+  listed by bitrise_remote_machine_list_specs.
+- wait: Wait until the VM has booted before returning, up to 5 minutes. Defaults to false. Progress
+  notifications are sent while waiting, like by bitrise_remote_machine_wait_for_ready.
+
+NOTES:
+- The specs are checked against bitrise_remote_machine_list_specs before the VM is created. If nothing
+  matches, the error lists the available stacks or machine types.
+- Check the versions of the created VM with bitrise_remote_machine_describe.
+
+RETURNS: A JSON object containing 'machine_id' (string) - save this for all subsequent operations - and the
+selected 'stack', 'machine_type' and 'lifetime_minutes' if specs were requested. With wait, also 'readiness',
+the result of waiting for the VM (see bitrise_remote_machine_wait_for_ready).`,
+		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithOutputSchema[createResult](),
+		mcp.WithString("stack",
+			mcp.Description("The ID of the stack to create the VM with"),
+		),
+		mcp.WithString("xcode_version",
+			mcp.Description("The Xcode version the stack must have, e.g. 16.4 or 16"),
+		),
+		mcp.WithString("macos_version",
+			mcp.Description("The macOS version the stack must have, e.g. 15.5 or 15"),
+		),
+		mcp.WithString("machine_type",
+			mcp.Description("The ID of the machine type to create the VM with"),
+		),
+		mcp.WithNumber("cpu_count",
+			mcp.Description("The minimum number of CPU cores of the machine type"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("ram_gb",
+			mcp.Description("The minimum RAM of the machine type, in GB"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("lifetime_minutes",
+			mcp.Description("How long the VM lives before it is deleted automatically, in minutes"),
+			mcp.Min(1),
+		),
+		mcp.WithBoolean("wait",
+			mcp.Description("Wait until the VM has booted before returning"),
 		),
 	),
+	Group: bitrise.GroupLifecycle,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    "/platform/me/machines",
-		})
+		req := specRequest{
+			Stack:           request.GetString("stack", ""),
+			XcodeVersion:    request.GetString("xcode_version", ""),
+			MacOSVersion:    request.GetString("macos_version", ""),
+			MachineType:     request.GetString("machine_type", ""),
+			CPUCount:        request.GetInt("cpu_count", 0),
+			RAMGB:           request.GetInt("ram_gb", 0),
+			LifetimeMinutes: request.GetInt("lifetime_minutes", 0),
+		}
+		var create bitrise.CreateMachineRequest
+		if req != (specRequest{}) {
+			specs, err := machineSpecs(ctx)
+			if err != nil {
+				return newToolResultAPIError("failed to list machine specs", err), nil
+			}
+			create, err = resolveSpecs(specs, req)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+		}
+
+		res, err := bitrise.Machines(ctx).Create(ctx, create)
+		if err != nil {
+			return newToolResultAPIError("failed to create remote machine", err), nil
+		}
+		result := createResult{
+			MachineID:       res.MachineID,
+			Stack:           create.Stack,
+			MachineType:     create.MachineType,
+			LifetimeMinutes: create.LifetimeMinutes,
+		}
+		if !request.GetBool("wait", false) {
+			return mcp.NewToolResultStructuredOnly(result), nil
+		}
+
+		readiness, err := waitForReady(ctx, res.MachineID, defaultReadyTimeout, newProgressReporter(ctx, request))
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to create remote machine", err), nil
+			return newToolResultAPIError(fmt.Sprintf("remote machine %s was created, but failed to wait for it", res.MachineID), err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		result.Readiness = &readiness
+		return mcp.NewToolResultStructuredOnly(result), nil
 	},
 }
+
+// createResult is the result of bitrise_remote_machine_create.
+type createResult struct {
+	MachineID       string     `json:"machine_id" jsonschema:"description=The ID of the new machine"`
+	Stack           string     `json:"stack,omitempty" jsonschema:"description=The stack selected for the requested specs"`
+	MachineType     string     `json:"machine_type,omitempty" jsonschema:"description=The machine type selected for the requested specs"`
+	LifetimeMinutes int        `json:"lifetime_minutes,omitempty" jsonschema:"description=The requested lifetime"`
+	Readiness       *readiness `json:"readiness,omitempty" jsonschema:"description=The result of waiting for the machine to boot\\, if wait was set"`
+}
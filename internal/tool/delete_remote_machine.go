@@ -3,7 +3,6 @@ package tool
 import (
 	"context"
 	"fmt"
-	"net/http"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -38,25 +37,24 @@ COST AWARENESS:
 REQUIRED PARAMETERS:
 - machine_id: The ID of the VM to delete (obtained from bitrise_remote_machine_create or bitrise_remote_machine_list).`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to delete"),
 			mcp.Required(),
 		),
 	),
+	Group: bitrise.GroupLifecycle,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodDelete,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s", machineID),
-		})
-		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to delete remote machine", err), nil
+		if err := bitrise.Machines(ctx).Delete(ctx, machineID); err != nil {
+			return newToolResultAPIError("failed to delete remote machine", err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultText(fmt.Sprintf("Successfully deleted remote machine %s", machineID)), nil
 	},
 }
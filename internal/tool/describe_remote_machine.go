@@ -0,0 +1,103 @@
+package tool
+
+import (
+	"context"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var DescribeRemoteMachine = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_describe",
+		mcp.WithDescription(
+			`Get the state and details of a remote macOS virtual machine.
+
+PURPOSE:
+Use this tool to find out whether a VM is still provisioning or booting, how much of its lifetime is left,
+and which stack (macOS and Xcode versions) and machine type it runs on, before starting work that depends on them.
+
+STATES:
+- provisioning: The VM is being allocated. Commands can't run yet.
+- booting: The VM is starting up. Commands can't run yet.
+- ready: Commands, file transfers and GUI tools can be used.
+- terminating: The VM is being deleted, because it was deleted or its lifetime ended. Create a new one.
+
+PARAMETERS:
+- machine_id (required): The VM to describe (obtained from bitrise_remote_machine_create or
+  bitrise_remote_machine_list).
+
+NOTES:
+- VMs are deleted automatically at expires_at. Save the results of long tasks (e.g. download the build
+  artifacts) before remaining_minutes runs out.
+- vnc_available is true once VNC access was enabled with bitrise_remote_machine_open_vnc.
+
+RETURNS: machine_id, state, created_at, expires_at, remaining_minutes, stack, machine_type, macos_version,
+xcode_version, ip_address and vnc_available.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[machineDetails](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to describe"),
+			mcp.Required(),
+		),
+	),
+	Group: bitrise.GroupLifecycle,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		machine, err := bitrise.Machines(ctx).Get(ctx, machineID)
+		if err != nil {
+			return newToolResultAPIError("failed to describe remote machine", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(newMachineDetails(machineID, machine, time.Now())), nil
+	},
+}
+
+// machineStateUnknown is the state of machines whose details couldn't be fetched.
+const machineStateUnknown = "unknown"
+
+// machineDetails describes a machine in the results of
+// bitrise_remote_machine_describe and bitrise_remote_machine_list.
+type machineDetails struct {
+	MachineID        string     `json:"machine_id" jsonschema:"description=The ID of the machine"`
+	State            string     `json:"state" jsonschema:"enum=provisioning,enum=booting,enum=ready,enum=terminating,enum=unknown,description=The lifecycle state. Commands can only run on ready machines"`
+	CreatedAt        *time.Time `json:"created_at,omitempty" jsonschema:"description=When the machine was created"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty" jsonschema:"description=When the machine is deleted automatically"`
+	RemainingMinutes int        `json:"remaining_minutes" jsonschema:"description=Whole minutes left until the machine is deleted automatically"`
+	Stack            string     `json:"stack,omitempty" jsonschema:"description=The ID of the stack (image) of the machine"`
+	MachineType      string     `json:"machine_type,omitempty" jsonschema:"description=The ID of the machine type (hardware) of the machine"`
+	MacOSVersion     string     `json:"macos_version,omitempty" jsonschema:"description=The macOS version of the image"`
+	XcodeVersion     string     `json:"xcode_version,omitempty" jsonschema:"description=The Xcode version of the image"`
+	IPAddress        string     `json:"ip_address,omitempty" jsonschema:"description=The IP address of the machine\\, once it has booted"`
+	VNCAvailable     bool       `json:"vnc_available" jsonschema:"description=True if VNC access is enabled"`
+	Error            string     `json:"error,omitempty" jsonschema:"description=Why the details couldn't be fetched\\, if the state is unknown"`
+}
+
+func newMachineDetails(machineID string, m *bitrise.Machine, now time.Time) machineDetails {
+	details := machineDetails{
+		MachineID:    machineID,
+		State:        m.State,
+		Stack:        m.Stack,
+		MachineType:  m.MachineType,
+		MacOSVersion: m.MacOSVersion,
+		XcodeVersion: m.XcodeVersion,
+		IPAddress:    m.IPAddress,
+		VNCAvailable: m.VNCEnabled,
+	}
+	if !m.CreatedAt.IsZero() {
+		createdAt := m.CreatedAt.UTC()
+		details.CreatedAt = &createdAt
+	}
+	if !m.ExpiresAt.IsZero() {
+		expiresAt := m.ExpiresAt.UTC()
+		details.ExpiresAt = &expiresAt
+		details.RemainingMinutes = max(int(expiresAt.Sub(now).Minutes()), 0)
+	}
+	return details
+}
@@ -1,15 +1,10 @@
 package tool
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -18,10 +13,6 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
-type downloadResponse struct {
-	SignedURL string `json:"signedUrl"`
-}
-
 var Download = bitrise.Tool{
 	Definition: mcp.NewTool("bitrise_remote_machine_download",
 		mcp.WithDescription(
@@ -48,16 +39,33 @@ PARAMETERS:
   of the folder will be archived and downloaded, not the folder itself. Defaults to false.
 - open_after_download (optional): If true, automatically opens the downloaded file with the system's
   default application. Defaults to false. Useful for viewing images, opening documents or applications.
+- on_conflict (optional): What to do with a downloaded file whose path already exists locally. Defaults to "overwrite".
+  - "overwrite": Replace the local file.
+  - "skip": Keep the local file, and don't write the downloaded one.
+  - "rename": Write the downloaded file next to the local one, with a number added to its name (e.g. "MyApp (1).ipa").
+  - "fail": Fail the download, and roll back the files it extracted so far.
+- dry_run (optional): If true, the archive is downloaded and checked, but nothing is written. The result lists
+  what would happen to every file with the given on_conflict. Defaults to false.
 
 IMPORTANT NOTES:
 - The content is downloaded as tar.gz and automatically extracted inside the destination_parent_folder.
 - Parent directories will be created automatically if they don't exist.
-- For large files like build artifacts, the download may take some time.
+- For large files like build artifacts, the download may take some time. The archive is extracted while it
+  is downloaded.
+- Downloads are limited in total size, number of files and size of a single file, so an unexpectedly large
+  folder (e.g. DerivedData) can't fill the local disk. Download a more specific path if a limit is exceeded.
+- If the download fails, it is rolled back: the files it created are removed and the files it overwrote are
+  restored, so no partial or mixed content is left behind.
+- Conflicts are decided file by file, folders are merged. With "rename", the files of an existing folder (e.g. an
+  .app bundle) are renamed one by one, so download a new version of a bundle to an empty folder instead.
+- When destination_parent_folder may contain local work (e.g. the project folder), use on_conflict="fail" or
+  dry_run=true first, instead of overwriting local files.
 
 ERROR HANDLING:
 - If download fails, read the error message carefully and RETRY the download.
 - DO NOT try to work around download failures by using execute commands (e.g., cat, base64, scp).
 - File transfers between remote and local MUST use this download tool or bitrise_remote_machine_upload.
+  To only look at a small text file, use bitrise_remote_machine_read_file instead.
 - Common issues to check before retrying:
   1. Verify the source_path exists on the VM (use bitrise_remote_machine_execute with "ls" to check).
   2. Ensure destination_parent_folder is a valid writable path locally.
@@ -74,8 +82,19 @@ EXAMPLE USAGE:
 1. bitrise_remote_machine_execute(machine_id="abc123", command="xcodebuild", args=["archive", ...])
 2. bitrise_remote_machine_download(machine_id="abc123", source_path="/Users/user/build/MyApp.ipa", destination_parent_folder="/local/builds")
 
-RETURNS: A success message or error details.`,
+PROGRESS:
+If the request has a progress token, progress notifications report the received bytes, the number of extracted
+files and the throughput while the download runs.
+
+RETURNS: A success message with the number of downloaded files and bytes, the size and compression ratio of the
+archive, the elapsed time, and the number of created, overwritten, renamed and skipped files with the outcome of
+each file (the first 100 are listed), or error details.`,
 		),
+		// Read-only as it doesn't modify the machine, so it is available in
+		// read-only mode, but destructive as it overwrites local files by default.
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to download from"),
 			mcp.Required(),
@@ -94,7 +113,15 @@ RETURNS: A success message or error details.`,
 		mcp.WithBoolean("open_after_download",
 			mcp.Description("If true, automatically opens the downloaded file with the system's default application"),
 		),
+		mcp.WithString("on_conflict",
+			mcp.Description("What to do with files that already exist locally. Defaults to overwrite"),
+			mcp.Enum(string(conflictOverwrite), string(conflictSkip), string(conflictRename), string(conflictFail)),
+		),
+		mcp.WithBoolean("dry_run",
+			mcp.Description("If true, lists what would be written without writing anything"),
+		),
 	),
+	Group: bitrise.GroupTransfer,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -113,41 +140,55 @@ RETURNS: A success message or error details.`,
 
 		onlyContentsOfFolder := request.GetBool("only_contents_of_folder", false)
 		openAfterDownload := request.GetBool("open_after_download", false)
-
-		// Step 1: Get download URL
-		body := map[string]any{
-			"sourcePath":           sourcePath,
-			"onlyContentsOfFolder": onlyContentsOfFolder,
+		dryRun := request.GetBool("dry_run", false)
+		onConflict := conflictPolicy(request.GetString("on_conflict", string(conflictOverwrite)))
+		switch onConflict {
+		case conflictOverwrite, conflictSkip, conflictRename, conflictFail:
+		default:
+			return mcp.NewToolResultError(fmt.Sprintf("invalid on_conflict %q, it must be overwrite, skip, rename or fail", onConflict)), nil
 		}
 
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    "/platform/me/machines/" + machineID + "/download",
-			Body:    body,
+		// Step 1: Get download URL
+		dlResp, err := bitrise.Machines(ctx).Download(ctx, machineID, bitrise.DownloadRequest{
+			SourcePath:           sourcePath,
+			OnlyContentsOfFolder: onlyContentsOfFolder,
 		})
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to get download URL", err), nil
-		}
-
-		var dlResp downloadResponse
-		if err := json.Unmarshal([]byte(res), &dlResp); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to parse download response: %v", err)), nil
+			return newToolResultAPIError("failed to get download URL", err), nil
 		}
 
-		// Step 2: Download from signed URL
-		data, err := downloadFromSignedURL(ctx, dlResp.SignedURL)
+		// Step 2: Stream the archive from the signed URL, and extract it while
+		// it is downloaded (like: curl | tar -xzf - -C destination)
+		progress := newTransferProgress(ctx, newProgressReporter(ctx, request), false, 0)
+		body, err := downloadFromSignedURL(ctx, dlResp.SignedURL, progress)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("failed to download from signed URL: %v", err)), nil
 		}
-
-		// Step 3: Extract tar.gz to destination (like: tar -xzf archive -C destination)
-		extractedPaths, err := extractTarGzWithPaths(data, destinationParentFolder)
+		extract := &extraction{
+			limits:     transferSettingsFromContext(ctx).extract,
+			onConflict: onConflict,
+			dryRun:     dryRun,
+			progress:   progress,
+		}
+		extractedPaths, err := extract.extractTarGz(progress.body(body), destinationParentFolder)
+		body.Close()
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to extract archive: %v", err)), nil
+			if dryRun {
+				return mcp.NewToolResultError(fmt.Sprintf("failed to download and check archive: %v", err)), nil
+			}
+			return mcp.NewToolResultError(fmt.Sprintf("failed to download and extract archive: %v (the download was rolled back: the files it created were removed and the files it overwrote were restored)", err)), nil
 		}
 
-		successMsg := fmt.Sprintf("Successfully downloaded %s from machine %s to %s", sourcePath, machineID, destinationParentFolder)
+		if dryRun {
+			return mcp.NewToolResultText(fmt.Sprintf("Dry run, nothing was written. Downloading %s from machine %s to %s (%d files, %d bytes) with on_conflict %s would result in %s",
+				sourcePath, machineID, destinationParentFolder, extract.files, extract.bytes, onConflict, extract.report())), nil
+		}
+
+		successMsg := fmt.Sprintf("Successfully downloaded %s from machine %s to %s (%d files, %d bytes)",
+			sourcePath, machineID, destinationParentFolder, extract.files, extract.bytes)
+		successMsg += fmt.Sprintf("\nThe archive (%d bytes, compression ratio %s) was downloaded and extracted in %s.",
+			progress.transferredBytes(), compressionRatio(extract.bytes, progress.transferredBytes()), progress.elapsed())
+		successMsg += "\nFiles: " + extract.report()
 
 		// Step 4: Open the downloaded file if requested
 		if openAfterDownload && len(extractedPaths) > 0 {
@@ -175,7 +216,10 @@ RETURNS: A success message or error details.`,
 	},
 }
 
-func downloadFromSignedURL(ctx context.Context, signedURL string) ([]byte, error) {
+// downloadFromSignedURL starts the download of the archive, and returns the
+// response body to stream it from. The size of the archive is set as the total
+// of progress.
+func downloadFromSignedURL(ctx context.Context, signedURL string, progress *transferProgress) (io.ReadCloser, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signedURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
@@ -186,105 +230,18 @@ func downloadFromSignedURL(ctx context.Context, signedURL string) ([]byte, error
 	if err != nil {
 		return nil, fmt.Errorf("execute request: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read response body: %w", err)
-	}
-
-	return data, nil
-}
-
-func extractTarGzWithPaths(data []byte, destPath string) ([]string, error) {
-	var extractedPaths []string
-	destPath = filepath.Clean(destPath)
-	if err := os.MkdirAll(destPath, 0755); err != nil {
-		return nil, fmt.Errorf("create destination directory: %w", err)
-	}
-
-	gzReader, err := gzip.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("create gzip reader: %w", err)
-	}
-	defer gzReader.Close()
-
-	tarReader := tar.NewReader(gzReader)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("read tar header: %w", err)
-		}
-
-		// Skip macOS AppleDouble files (extended attributes stored as ._filename)
-		baseName := filepath.Base(header.Name)
-		if strings.HasPrefix(baseName, "._") {
-			continue
-		}
-
-		targetPath := filepath.Join(destPath, filepath.Clean(header.Name))
-
-		// Security: ensure target is within destination
-		if !isWithinDir(targetPath, destPath) {
-			return nil, fmt.Errorf("path traversal detected: %s", header.Name)
-		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return nil, fmt.Errorf("create directory %s: %w", targetPath, err)
-			}
-			extractedPaths = append(extractedPaths, targetPath)
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return nil, fmt.Errorf("create parent directory: %w", err)
-			}
-			if err := extractFile(tarReader, targetPath, header.Mode); err != nil {
-				return nil, err
-			}
-			extractedPaths = append(extractedPaths, targetPath)
-		case tar.TypeSymlink:
-			// Validate symlink target
-			linkTarget := filepath.Join(filepath.Dir(targetPath), header.Linkname)
-			if !isWithinDir(linkTarget, destPath) {
-				return nil, fmt.Errorf("symlink escapes destination: %s -> %s", header.Name, header.Linkname)
-			}
-			os.Remove(targetPath)
-			if err := os.Symlink(header.Linkname, targetPath); err != nil {
-				return nil, fmt.Errorf("create symlink: %w", err)
-			}
-			extractedPaths = append(extractedPaths, targetPath)
-		}
-	}
-	return extractedPaths, nil
-}
-
-func isWithinDir(path, dir string) bool {
-	path = filepath.Clean(path)
-	dir = filepath.Clean(dir)
-	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
-}
-
-func extractFile(tarReader *tar.Reader, targetPath string, mode int64) error {
-	file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
-	if err != nil {
-		return fmt.Errorf("create file %s: %w", targetPath, err)
+	if progress != nil && resp.ContentLength > 0 {
+		// Nothing was read yet, so the progress can't be reported concurrently.
+		progress.total = resp.ContentLength
 	}
-	defer file.Close()
-
-	if _, err := io.Copy(file, tarReader); err != nil {
-		return fmt.Errorf("write file %s: %w", targetPath, err)
-	}
-
-	return nil
+	return resp.Body, nil
 }
 
 // getTopLevelItems returns only the top-level items (files or folders) directly in the destination folder.
@@ -0,0 +1,858 @@
+package tool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/fakeapi"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestBuildWorkflow(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+
+	project := filepath.Join(t.TempDir(), "MyApp")
+	writeFiles(t, project, map[string]string{
+		"Sources/main.swift": "print(\"hello\")\n",
+		"README.md":          "# MyApp\n",
+	})
+	h.mustCallTool("bitrise_remote_machine_upload", map[string]any{
+		"machine_id":                id,
+		"source_path":               project,
+		"destination_parent_folder": fakeapi.HomeDir + "/work",
+	})
+
+	res := h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "cd work/MyApp && cat Sources/main.swift && mkdir -p build && echo artifact > build/MyApp.ipa",
+	})
+	if got := commandResult(t, res).Stdout; got != "print(\"hello\")\n" {
+		t.Errorf("execute output doesn't contain the uploaded file: %q", got)
+	}
+
+	downloads := t.TempDir()
+	h.mustCallTool("bitrise_remote_machine_download", map[string]any{
+		"machine_id":                id,
+		"source_path":               fakeapi.HomeDir + "/work/MyApp/build",
+		"destination_parent_folder": downloads,
+	})
+	assertFile(t, filepath.Join(downloads, "build", "MyApp.ipa"), "artifact\n")
+
+	h.mustCallTool("bitrise_remote_machine_delete", map[string]any{"machine_id": id})
+	if _, ok := h.fake.Machine(id); ok {
+		t.Errorf("machine %s still exists after delete", id)
+	}
+	res = h.callTool("bitrise_remote_machine_execute", map[string]any{"machine_id": id, "bash_command": "true"})
+	if !res.IsError || !strings.Contains(resultText(res), "bitrise_remote_machine_list") {
+		t.Errorf("execute on a deleted machine: want not found error with hint, got %q", resultText(res))
+	}
+}
+
+func TestExecuteResult(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+
+	res := h.callTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "printf out; printf err >&2; exit 3",
+	})
+	if !res.IsError {
+		t.Error("non-zero exit code is not reported as an error")
+	}
+	got := commandResult(t, res)
+	if got.ExitCode != 3 || got.Stdout != "out" || got.Stderr != "err" || got.Truncated {
+		t.Errorf("got %+v, want exit code 3, stdout \"out\" and stderr \"err\"", got)
+	}
+
+	res = h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "echo 'quoted \"$HOME\"'; echo; echo done",
+	})
+	if got := commandResult(t, res); got.ExitCode != 0 || got.Stdout != "quoted \"$HOME\"\n\ndone\n" {
+		t.Errorf("got %+v", got)
+	}
+
+	res = h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "head -c 300000 /dev/zero | tr '\\0' x",
+	})
+	got = commandResult(t, res)
+	if !got.Truncated || len(got.Stdout) > DefaultOutputHeadBytes+DefaultOutputTailBytes+200 {
+		t.Errorf("got %d bytes of stdout, truncated: %v", len(got.Stdout), got.Truncated)
+	}
+	if !strings.Contains(got.Stdout, "bytes omitted") || !strings.Contains(got.Stdout, "bitrise_remote_machine_command_output") {
+		t.Errorf("truncated stdout has no marker: %q", got.Stdout[DefaultOutputHeadBytes-10:DefaultOutputHeadBytes+200])
+	}
+}
+
+func TestCommandOutput(t *testing.T) {
+	logDir := t.TempDir()
+	h := newHarness(t, withBeltOptions(WithOutputLimit(100, 50), WithCommandLogDir(logDir)))
+	id := h.createMachine()
+
+	res := h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "for i in $(seq 1 500); do echo \"line $i\"; done; echo failure >&2",
+	})
+	var execResult executeResult
+	decodeStructured(t, res, &execResult)
+	if !execResult.Truncated || execResult.CommandID == "" {
+		t.Fatalf("got %+v, want truncated output with a command ID", execResult)
+	}
+	if !strings.HasPrefix(execResult.Stdout, "line 1\n") || !strings.HasSuffix(execResult.Stdout, "line 500\n") {
+		t.Errorf("truncated stdout doesn't keep the beginning and the end: %q", execResult.Stdout)
+	}
+
+	var page commandOutputResult
+	res = h.mustCallTool("bitrise_remote_machine_command_output", map[string]any{
+		"command_id": execResult.CommandID,
+		"start_line": 201,
+		"max_lines":  3,
+	})
+	decodeStructured(t, res, &page)
+	want := []outputLine{{201, "line 201"}, {202, "line 202"}, {203, "line 203"}}
+	if page.TotalLines != 500 || page.NextLine != 204 || !slices.Equal(page.Lines, want) {
+		t.Errorf("got page %+v", page)
+	}
+
+	res = h.mustCallTool("bitrise_remote_machine_command_output", map[string]any{
+		"command_id": execResult.CommandID,
+		"pattern":    "^line 4[0-9]9$",
+	})
+	decodeStructured(t, res, &page)
+	if len(page.Lines) != 10 || page.Lines[0].Number != 409 || page.NextLine != 0 {
+		t.Errorf("got grep result %+v", page)
+	}
+
+	res = h.mustCallTool("bitrise_remote_machine_command_output", map[string]any{
+		"command_id": execResult.CommandID,
+		"stream":     "stderr",
+	})
+	decodeStructured(t, res, &page)
+	if want := []outputLine{{1, "failure"}}; !slices.Equal(page.Lines, want) {
+		t.Errorf("got stderr %+v", page.Lines)
+	}
+
+	res = h.callTool("bitrise_remote_machine_command_output", map[string]any{"command_id": "0123456789abcdef"})
+	if !res.IsError || !strings.Contains(resultText(res), "not found") {
+		t.Errorf("unknown command ID: want not found error, got %q", resultText(res))
+	}
+
+	// The output of a command can only be read with the token it was run with.
+	log := &commandLog{dir: logDir}
+	ctx := bitrise.ContextWithPAT(context.Background(), "other-token")
+	if _, err := log.open(ctx, execResult.CommandID, "stdout"); !errors.Is(err, errCommandLogNotFound) {
+		t.Errorf("open with another token: got %v, want not found", err)
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	backoff := uploadRetryBackoff
+	uploadRetryBackoff = time.Millisecond
+	t.Cleanup(func() { uploadRetryBackoff = backoff })
+
+	h := newHarness(t, withBeltOptions(WithUploadPartSize(64<<10)))
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+
+	// Random content doesn't compress, so the archive needs several parts.
+	content := make([]byte, 300<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(t.TempDir(), "MyApp")
+	writeFiles(t, project, map[string]string{"assets/blob.bin": string(content), "README.md": "# MyApp\n"})
+
+	h.fake.FailUploads(2)
+	res := h.mustCallTool("bitrise_remote_machine_upload", map[string]any{
+		"machine_id":                id,
+		"source_path":               project,
+		"destination_parent_folder": "work",
+	})
+	if got := resultText(res); !strings.Contains(got, "uploaded in 5 part(s) in ") || !strings.Contains(got, "2 failed part upload(s) were retried") {
+		t.Errorf("got %q", got)
+	}
+	assertFile(t, m.Path("work/MyApp/assets/blob.bin"), string(content))
+	assertFile(t, m.Path("work/MyApp/README.md"), "# MyApp\n")
+	if staging, _ := filepath.Glob(m.Path("work/.bitrise-mcp-upload-*")); len(staging) != 0 {
+		t.Errorf("staging folder was not removed: %v", staging)
+	}
+
+	h.fake.FailUploads(uploadPartAttempts)
+	res = h.callTool("bitrise_remote_machine_upload", map[string]any{
+		"machine_id":                id,
+		"source_path":               project,
+		"destination_parent_folder": "work",
+	})
+	if !res.IsError || !strings.Contains(resultText(res), "upload part 1") {
+		t.Errorf("want error after %d failed attempts, got %q", uploadPartAttempts, resultText(res))
+	}
+}
+
+func TestTransferProgress(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	project := filepath.Join(t.TempDir(), "MyApp")
+	writeFiles(t, project, map[string]string{
+		"Sources/App.swift": strings.Repeat("print(\"hello\")\n", 1000),
+		"README.md":         "# MyApp\n",
+	})
+
+	progressOf := func(action string) (total float64) {
+		t.Helper()
+		var sent int
+		for _, n := range h.notifications() {
+			if n.Method != methodNotificationProgress {
+				continue
+			}
+			params := n.Params.AdditionalFields
+			if params["progressToken"] != action {
+				t.Errorf("got progress token %v, want %s", params["progressToken"], action)
+			}
+			message, _ := params["message"].(string)
+			if !strings.HasPrefix(strings.ToLower(message), action+"ing: ") || !strings.HasSuffix(message, "/s") {
+				t.Errorf("got progress message %q", message)
+			}
+			progress, _ := params["progress"].(float64)
+			total, _ = params["total"].(float64)
+			if progress <= 0 || progress > total {
+				t.Errorf("got progress %v of %v", progress, total)
+			}
+			sent++
+		}
+		if sent == 0 {
+			t.Errorf("no %s progress was sent", action)
+		}
+		return total
+	}
+
+	res := h.callToolWithMeta("bitrise_remote_machine_upload", map[string]any{
+		"machine_id":                id,
+		"source_path":               project,
+		"destination_parent_folder": "work",
+	}, map[string]any{"progressToken": "upload"})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "(2 files, 15008 bytes)") || !strings.Contains(got, "compression ratio ") {
+		t.Errorf("got %q", got)
+	}
+	if total := progressOf("upload"); total != 15008 {
+		t.Errorf("got upload total %v, want the size of the files", total)
+	}
+
+	dest := t.TempDir()
+	res = h.callToolWithMeta("bitrise_remote_machine_download", map[string]any{
+		"machine_id":                id,
+		"source_path":               "work/MyApp",
+		"destination_parent_folder": dest,
+	}, map[string]any{"progressToken": "download"})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "(2 files, 15008 bytes)") || !strings.Contains(got, "was downloaded and extracted in ") {
+		t.Errorf("got %q", got)
+	}
+	if total := progressOf("download"); total <= 0 || total >= 15008 {
+		t.Errorf("got download total %v, want the size of the compressed archive", total)
+	}
+}
+
+func TestDownloadConflicts(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+	writeFiles(t, m.Path("build"), map[string]string{"MyApp.ipa": "new ipa", "report.txt": "new report"})
+
+	dest := t.TempDir()
+	writeFiles(t, dest, map[string]string{"build/MyApp.ipa": "local ipa", "build/MyApp (1).ipa": "older ipa"})
+	download := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		args["machine_id"] = id
+		args["source_path"] = "build"
+		args["destination_parent_folder"] = dest
+		return h.callTool("bitrise_remote_machine_download", args)
+	}
+
+	res := download(map[string]any{"on_conflict": "fail", "dry_run": true})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "Dry run") ||
+		!strings.Contains(got, "1 created, 0 overwritten, 0 renamed, 0 skipped, 1 conflicting") ||
+		!strings.Contains(got, "- conflict build/MyApp.ipa") || !strings.Contains(got, "- created build/report.txt") {
+		t.Errorf("dry run: got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "build/report.txt")); !os.IsNotExist(err) {
+		t.Error("dry run wrote a file")
+	}
+
+	res = download(map[string]any{"on_conflict": "fail"})
+	if !res.IsError || !strings.Contains(resultText(res), "build/MyApp.ipa already exists") {
+		t.Errorf("fail: got %q", resultText(res))
+	}
+	if _, err := os.Stat(filepath.Join(dest, "build/report.txt")); !os.IsNotExist(err) {
+		t.Error("failed download left a file behind")
+	}
+
+	res = download(map[string]any{"on_conflict": "skip"})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "- skipped build/MyApp.ipa") {
+		t.Errorf("skip: got %q", got)
+	}
+	assertFile(t, filepath.Join(dest, "build/MyApp.ipa"), "local ipa")
+	assertFile(t, filepath.Join(dest, "build/report.txt"), "new report")
+
+	res = download(map[string]any{"on_conflict": "rename"})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "- renamed build/MyApp.ipa -> build/MyApp (2).ipa") {
+		t.Errorf("rename: got %q", got)
+	}
+	assertFile(t, filepath.Join(dest, "build/MyApp.ipa"), "local ipa")
+	assertFile(t, filepath.Join(dest, "build/MyApp (2).ipa"), "new ipa")
+
+	res = download(map[string]any{})
+	if got := resultText(res); res.IsError || !strings.Contains(got, "0 created, 2 overwritten") {
+		t.Errorf("overwrite: got %q", got)
+	}
+	assertFile(t, filepath.Join(dest, "build/MyApp.ipa"), "new ipa")
+
+	res = download(map[string]any{"on_conflict": "merge"})
+	if !res.IsError || !strings.Contains(resultText(res), "invalid on_conflict") {
+		t.Errorf("invalid policy: got %q", resultText(res))
+	}
+}
+
+// firstContent returns the first of the resource contents, or nil.
+func firstContent(contents []mcp.ResourceContents) mcp.ResourceContents {
+	if len(contents) == 0 {
+		return nil
+	}
+	return contents[0]
+}
+
+func TestListAndQuota(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+
+	var list listMachinesResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list", nil), &list)
+	if !slices.Equal(list.MachineIDs, []string{id}) || len(list.Machines) != 1 {
+		t.Fatalf("list doesn't contain machine %s: %+v", id, list)
+	}
+	got := list.Machines[0]
+	if got.MachineID != id || got.State != bitrise.MachineStateReady || got.Stack != fakeapi.DefaultStack ||
+		got.MachineType != fakeapi.DefaultMachineType || got.MacOSVersion != fakeapi.MacOSVersion ||
+		got.XcodeVersion != fakeapi.XcodeVersion || got.IPAddress == "" || got.VNCAvailable {
+		t.Errorf("got machine %+v", got)
+	}
+	if got.CreatedAt == nil || got.ExpiresAt == nil || got.ExpiresAt.Sub(*got.CreatedAt) != fakeapi.MachineLifetime ||
+		got.RemainingMinutes < 58 || got.RemainingMinutes > 60 {
+		t.Errorf("got lifetime %v - %v, %d minutes left", got.CreatedAt, got.ExpiresAt, got.RemainingMinutes)
+	}
+
+	h.mustCallTool("bitrise_remote_machine_open_vnc", map[string]any{"machine_id": id})
+	var described machineDetails
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_describe", map[string]any{"machine_id": id}), &described)
+	if described.MachineID != id || described.State != bitrise.MachineStateReady || !described.VNCAvailable {
+		t.Errorf("got described machine %+v", described)
+	}
+	res := h.callTool("bitrise_remote_machine_describe", map[string]any{"machine_id": "missing"})
+	if !res.IsError || !strings.Contains(resultText(res), "bitrise_remote_machine_list") {
+		t.Errorf("describe missing machine: got %q", resultText(res))
+	}
+
+	res = h.callTool("bitrise_remote_machine_create", nil)
+	if !res.IsError || !strings.Contains(resultText(res), "reuse the existing machine") {
+		t.Errorf("second create: want quota error with hint, got %q", resultText(res))
+	}
+}
+
+func TestListWithoutMachineDetails(t *testing.T) {
+	// An API without the endpoint describing a single machine.
+	h := newHarness(t, withAPIHandler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/platform/me/machines/") {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+	id := h.createMachine()
+
+	var list listMachinesResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list", nil), &list)
+	if !slices.Equal(list.MachineIDs, []string{id}) || len(list.Machines) != 1 {
+		t.Fatalf("list doesn't contain machine %s: %+v", id, list)
+	}
+	if got := list.Machines[0]; got.MachineID != id || got.State != machineStateUnknown || got.Error == "" {
+		t.Errorf("got machine %+v, want unknown state with an error", got)
+	}
+}
+
+func TestMachineSpecs(t *testing.T) {
+	h := newHarness(t)
+
+	var specs machineSpecsResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list_specs", nil), &specs)
+	if len(specs.Stacks) != 3 || len(specs.MachineTypes) != 3 || specs.DefaultStack != fakeapi.DefaultStack ||
+		specs.DefaultMachineType != fakeapi.DefaultMachineType || specs.MaxLifetimeMinutes != int(fakeapi.MaxMachineLifetime.Minutes()) {
+		t.Errorf("got specs %+v", specs)
+	}
+
+	for _, tc := range []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{"xcode_version": "14"}, "Available stacks: osx-xcode-16.4.x (macOS 15.5, Xcode 16.4)"},
+		{map[string]any{"stack": "osx-xcode-16.2.x", "macos_version": "15.5"}, `no stack matches ID "osx-xcode-16.2.x", macOS 15.5`},
+		{map[string]any{"ram_gb": 64}, "no machine type matches at least 64 GB RAM. Available machine types: g2.mac.medium (4 CPUs, 12 GB RAM)"},
+		{map[string]any{"lifetime_minutes": 1000}, "lifetime_minutes must be at most 240"},
+	} {
+		res := h.callTool("bitrise_remote_machine_create", tc.args)
+		if !res.IsError || !strings.Contains(resultText(res), tc.want) {
+			t.Errorf("create with %v: got %q, want %q", tc.args, resultText(res), tc.want)
+		}
+	}
+
+	var created createResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_create", map[string]any{
+		"xcode_version":    "16",
+		"cpu_count":        6,
+		"lifetime_minutes": 120,
+	}), &created)
+	if created.Stack != fakeapi.DefaultStack || created.MachineType != "g2.mac.large" || created.LifetimeMinutes != 120 {
+		t.Errorf("got created %+v, want the newest Xcode 16 stack and the smallest machine type with 6 CPUs", created)
+	}
+	var described machineDetails
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_describe", map[string]any{"machine_id": created.MachineID}), &described)
+	if described.Stack != created.Stack || described.MachineType != "g2.mac.large" || described.XcodeVersion != "16.4" ||
+		described.ExpiresAt.Sub(*described.CreatedAt) != 120*time.Minute {
+		t.Errorf("got described %+v", described)
+	}
+	h.mustCallTool("bitrise_remote_machine_delete", map[string]any{"machine_id": created.MachineID})
+
+	created = createResult{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_create", map[string]any{"xcode_version": "15.4"}), &created)
+	described = machineDetails{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_describe", map[string]any{"machine_id": created.MachineID}), &described)
+	if described.Stack != "osx-xcode-15.4.x" || described.MacOSVersion != "14.5" || described.MachineType != fakeapi.DefaultMachineType {
+		t.Errorf("got described %+v, want the Xcode 15.4 stack on the default machine type", described)
+	}
+
+	// A configured catalog is used instead of the one of the API.
+	h = newHarness(t, withBeltOptions(WithMachineSpecs(&bitrise.MachineSpecs{
+		Stacks:       []bitrise.Stack{{ID: "osx-xcode-16.2.x", MacOSVersion: "15.3", XcodeVersion: "16.2"}},
+		MachineTypes: []bitrise.MachineType{{ID: fakeapi.DefaultMachineType, CPUCount: 4, RAMGB: 12}},
+	})))
+	specs = machineSpecsResult{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list_specs", nil), &specs)
+	if len(specs.Stacks) != 1 || specs.MaxLifetimeMinutes != 0 {
+		t.Errorf("got configured specs %+v", specs)
+	}
+	res := h.callTool("bitrise_remote_machine_create", map[string]any{"xcode_version": "16.4"})
+	if !res.IsError || !strings.Contains(resultText(res), "Available stacks: osx-xcode-16.2.x (macOS 15.3, Xcode 16.2)") {
+		t.Errorf("create with a stack missing from the configured catalog: got %q", resultText(res))
+	}
+	created = createResult{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_create", map[string]any{"xcode_version": "16.2"}), &created)
+	if created.Stack != "osx-xcode-16.2.x" {
+		t.Errorf("got created %+v", created)
+	}
+}
+
+func TestMachineSpecsWithoutCatalog(t *testing.T) {
+	// An API without the machine specs endpoint.
+	h := newHarness(t, withAPIHandler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/platform/me/machine_specs" {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+
+	res := h.callTool("bitrise_remote_machine_create", map[string]any{"xcode_version": "16"})
+	if got := resultText(res); !res.IsError || !strings.Contains(got, "MCP_MACHINE_SPECS_FILE") ||
+		strings.Contains(got, "does not exist") {
+		t.Errorf("create with specs: got %q, want an error about the missing catalog", got)
+	}
+	h.createMachine()
+}
+
+func TestGUIInteractions(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	h := newHarness(t)
+	id := h.createMachine()
+
+	h.mustCallTool("bitrise_remote_machine_click", map[string]any{"machine_id": id, "x": 10, "y": 20, "button": "left"})
+	h.mustCallTool("bitrise_remote_machine_type", map[string]any{"machine_id": id, "text": "hello"})
+	h.mustCallTool("bitrise_remote_machine_scroll", map[string]any{"machine_id": id, "direction": "down", "amount": 3})
+	h.mustCallTool("bitrise_remote_machine_mouse_drag", map[string]any{
+		"machine_id": id, "start_x": 1, "start_y": 2, "end_x": 3, "end_y": 4,
+	})
+
+	m, ok := h.fake.Machine(id)
+	if !ok {
+		t.Fatalf("machine %s not found", id)
+	}
+	var actions []string
+	for _, event := range m.Events() {
+		actions = append(actions, event.Action)
+	}
+	if want := []string{"click", "type", "scroll", "mouse_drag"}; !slices.Equal(actions, want) {
+		t.Errorf("got events %v, want %v", actions, want)
+	}
+
+	res := h.mustCallTool("bitrise_remote_machine_screenshot", map[string]any{"machine_id": id})
+	if len(res.Content) == 0 {
+		t.Fatal("screenshot returned no content")
+	}
+	image, ok := mcp.AsImageContent(res.Content[0])
+	if !ok || image.MIMEType != "image/jpeg" || image.Data == "" {
+		t.Errorf("screenshot: want a JPEG image, got %#v", res.Content[0])
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	h := newHarness(t, withBeltOptions(WithReadOnly()))
+
+	for _, tool := range h.listTools() {
+		if tool.Annotations.ReadOnlyHint == nil || !*tool.Annotations.ReadOnlyHint {
+			t.Errorf("tool %s is registered in read-only mode", tool.Name)
+		}
+	}
+	if names := toolNames(h.listTools()); !slices.Contains(names, "bitrise_remote_machine_list") {
+		t.Errorf("read-only tools are not registered: %v", names)
+	}
+
+	// Mutating tools that are registered anyway are rejected by the middleware.
+	handler := NewBelt(WithReadOnly()).Middleware(CreateRemoteMachine.Handler)
+	request := mcp.CallToolRequest{}
+	request.Params.Name = CreateRemoteMachine.Definition.Name
+	res, err := handler(context.Background(), request)
+	if err != nil || !res.IsError || !strings.Contains(resultText(res), "read-only mode") {
+		t.Errorf("create in read-only mode: want read-only error, got %v, %v", res, err)
+	}
+}
+
+func TestToolGroups(t *testing.T) {
+	h := newHarness(t, withRegisteredGroups(bitrise.GroupLifecycle, bitrise.GroupExec, bitrise.GroupGUI))
+	if names := toolNames(h.listTools()); slices.Contains(names, "bitrise_remote_machine_upload") {
+		t.Errorf("transfer tools are registered: %v", names)
+	}
+
+	h = newHarness(t, withSessionContext(func(ctx context.Context) context.Context {
+		return bitrise.ContextWithEnabledGroups(ctx, []string{bitrise.GroupLifecycle})
+	}))
+	names := toolNames(h.listTools())
+	for _, name := range names {
+		if tool := NewBelt().tools[name]; tool.Group != bitrise.GroupLifecycle {
+			t.Errorf("tool %s of group %s is listed for the session", name, tool.Group)
+		}
+	}
+	if len(names) == 0 {
+		t.Error("no tools are listed for the session")
+	}
+	res := h.callTool("bitrise_remote_machine_execute", map[string]any{"machine_id": "x", "bash_command": "true"})
+	if !res.IsError || !strings.Contains(resultText(res), "not enabled") {
+		t.Errorf("execute outside the enabled groups: want error, got %q", resultText(res))
+	}
+}
+
+// commandResult decodes the structured content of an execute result.
+func commandResult(t *testing.T, res *mcp.CallToolResult) bitrise.CommandResult {
+	t.Helper()
+	var result bitrise.CommandResult
+	if err := json.Unmarshal([]byte(resultText(res)), &result); err != nil {
+		t.Fatalf("unmarshal command result: %v", err)
+	}
+	data, err := json.Marshal(res.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var structured bitrise.CommandResult
+	if err := json.Unmarshal(data, &structured); err != nil || structured != result {
+		t.Errorf("structured content %s doesn't match the text content", data)
+	}
+	return result
+}
+
+func toolNames(tools []mcp.Tool) []string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("read %s: %v", path, err)
+		return
+	}
+	if string(got) != want {
+		t.Errorf("%s: got %q, want %q", path, got, want)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+
+	res := h.mustCallTool("bitrise_remote_machine_job_start", map[string]any{
+		"machine_id":   id,
+		"bash_command": "echo first; echo oops >&2; sleep 0.5; echo second; exit 4",
+	})
+	var started bitrise.JobStatus
+	decodeStructured(t, res, &started)
+	if started.JobID == "" || started.State != bitrise.JobRunning {
+		t.Fatalf("got %+v, want a running job", started)
+	}
+
+	var output bitrise.JobOutput
+	var stdout, stderr string
+	for range 50 {
+		res = h.mustCallTool("bitrise_remote_machine_job_output", map[string]any{
+			"machine_id":    id,
+			"job_id":        started.JobID,
+			"stdout_offset": output.NextStdoutOffset,
+			"stderr_offset": output.NextStderrOffset,
+			"max_bytes":     4,
+		})
+		decodeStructured(t, res, &output)
+		stdout += output.Stdout
+		stderr += output.Stderr
+		if output.State != bitrise.JobRunning && output.NextStdoutOffset == output.StdoutBytes &&
+			output.NextStderrOffset == output.StderrBytes {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if output.State != bitrise.JobExited || output.ExitCode == nil || *output.ExitCode != 4 {
+		t.Errorf("got state %s and exit code %v, want exited with 4", output.State, output.ExitCode)
+	}
+	if stdout != "first\nsecond\n" || stderr != "oops\n" {
+		t.Errorf("got stdout %q and stderr %q", stdout, stderr)
+	}
+
+	res = h.mustCallTool("bitrise_remote_machine_job_start", map[string]any{
+		"machine_id":   id,
+		"bash_command": "sleep 60 & sleep 60",
+	})
+	decodeStructured(t, res, &started)
+	res = h.mustCallTool("bitrise_remote_machine_job_cancel", map[string]any{
+		"machine_id": id,
+		"job_id":     started.JobID,
+	})
+	var canceled bitrise.JobStatus
+	decodeStructured(t, res, &canceled)
+	if canceled.State != bitrise.JobCanceled || canceled.Signal != "TERM" {
+		t.Errorf("got %+v, want canceled with TERM", canceled)
+	}
+
+	// Commands with a timeout run in a process group of their own, which is canceled too.
+	ctx := bitrise.ContextWithPAT(bitrise.ContextWithClient(context.Background(), h.client), testToken)
+	job, err := bitrise.Machines(ctx).StartJob(ctx, id, bitrise.Command{Script: "echo $$ >timed.pid; sleep 60", Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("start timed job: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if _, err := bitrise.Machines(ctx).CancelJob(ctx, id, job.JobID, "TERM"); err != nil {
+		t.Fatalf("cancel timed job: %v", err)
+	}
+	res = h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": `case "$(ps -o stat= -p "$(cat timed.pid)" | tr -d " ")" in ""|Z*) echo gone ;; *) echo alive ;; esac`,
+	})
+	if got := commandResult(t, res); got.Stdout != "gone\n" {
+		t.Errorf("timed command of canceled job: got %q, want gone", got.Stdout)
+	}
+
+	res = h.callTool("bitrise_remote_machine_job_status", map[string]any{"machine_id": id, "job_id": "0123456789abcdef"})
+	if !res.IsError || !strings.Contains(resultText(res), "job not found") {
+		t.Errorf("status of unknown job: want not found error, got %q", resultText(res))
+	}
+}
+
+// decodeStructured decodes the structured content of a tool result.
+func decodeStructured(t *testing.T, res *mcp.CallToolResult, v any) {
+	t.Helper()
+	data, err := json.Marshal(res.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("unmarshal structured content %s: %v", data, err)
+	}
+}
+
+func TestExecuteStreamsProgress(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+
+	res := h.callToolWithMeta("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "echo compiling; sleep 0.5; echo warning >&2; echo linking; printf done; exit 2",
+	}, map[string]any{"progressToken": "build"})
+	got := commandResult(t, res)
+	if !res.IsError || got.ExitCode != 2 || got.Stdout != "compiling\nlinking\ndone" || got.Stderr != "warning\n" {
+		t.Errorf("got %+v, want the same result as without streaming", got)
+	}
+
+	var messages []string
+	lastProgress := 0.0
+	for _, n := range h.notifications() {
+		if n.Method != methodNotificationProgress {
+			continue
+		}
+		params := n.Params.AdditionalFields
+		if params["progressToken"] != "build" {
+			t.Errorf("got progress token %v, want build", params["progressToken"])
+		}
+		progress, _ := params["progress"].(float64)
+		if progress <= lastProgress {
+			t.Errorf("progress %v doesn't increase after %v", progress, lastProgress)
+		}
+		lastProgress = progress
+		message, _ := params["message"].(string)
+		messages = append(messages, strings.Split(message, "\n")...)
+	}
+	slices.Sort(messages)
+	if want := []string{"[stderr] warning", "compiling", "done", "linking"}; !slices.Equal(messages, want) {
+		t.Errorf("got streamed lines %q, want %q", messages, want)
+	}
+}
+
+func TestExecuteOptions(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	h.mustCallTool("bitrise_remote_machine_execute", map[string]any{"machine_id": id, "bash_command": "mkdir -p 'my app'"})
+
+	res := h.mustCallTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": `basename "$PWD"; echo "$CONFIGURATION $API_TOKEN"; tr a-z A-Z`,
+		"cwd":          "my app",
+		"env":          map[string]any{"CONFIGURATION": "Release", "API_TOKEN": "s3cr3t 'quoted'"},
+		"stdin":        "from stdin",
+	})
+	if got := commandResult(t, res); got.Stdout != "my app\n*** ***\nFROM STDIN" {
+		t.Errorf("got stdout %q", got.Stdout)
+	}
+
+	res = h.callToolWithMeta("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": `echo "token: $API_TOKEN"`,
+		"env":          map[string]any{"API_TOKEN": "s3cr3t"},
+	}, map[string]any{"progressToken": 1})
+	if got := commandResult(t, res); got.Stdout != "token: ***\n" {
+		t.Errorf("got streamed stdout %q", got.Stdout)
+	}
+	for _, n := range h.notifications() {
+		if data, _ := json.Marshal(n); strings.Contains(string(data), "s3cr3t") {
+			t.Errorf("notification leaks the secret: %s", data)
+		}
+	}
+
+	start := time.Now()
+	res = h.callTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":      id,
+		"bash_command":    "echo started; sleep 30 & sleep 30",
+		"timeout_seconds": 1,
+	})
+	got := commandResult(t, res)
+	if !res.IsError || !got.TimedOut || got.ExitCode != bitrise.TimeoutExitCode || got.Stdout != "started\n" {
+		t.Errorf("got %+v, want a timed out command", got)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("timed out command returned after %s", elapsed)
+	}
+
+	if strings.Contains(got.Stderr, "__bitrise_mcp") || !strings.Contains(got.Stderr, "command timed out after 1 seconds") {
+		t.Errorf("got stderr %q of a timed out command", got.Stderr)
+	}
+
+	// A command exiting with the timeout exit code by itself didn't time out.
+	for _, meta := range []map[string]any{nil, {"progressToken": 2}} {
+		res = h.callToolWithMeta("bitrise_remote_machine_execute", map[string]any{
+			"machine_id":      id,
+			"bash_command":    "exit 124",
+			"timeout_seconds": 10,
+		}, meta)
+		if got := commandResult(t, res); got.TimedOut || got.ExitCode != bitrise.TimeoutExitCode {
+			t.Errorf("with meta %v: got %+v, want exit code 124 without timing out", meta, got)
+		}
+	}
+	res = h.callToolWithMeta("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":      id,
+		"bash_command":    "sleep 30",
+		"timeout_seconds": 1,
+	}, map[string]any{"progressToken": 3})
+	if got := commandResult(t, res); !got.TimedOut || strings.Contains(got.Stderr, "__bitrise_mcp") {
+		t.Errorf("streamed: got %+v, want a timed out command", got)
+	}
+
+	start = time.Now()
+	res = h.callTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":      id,
+		"bash_command":    "head -c 5; echo; sleep 30; echo finished",
+		"stdin":           "input",
+		"timeout_seconds": 1,
+	})
+	got = commandResult(t, res)
+	if !got.TimedOut || got.ExitCode != bitrise.TimeoutExitCode || got.Stdout != "input\n" {
+		t.Errorf("got %+v, want a timed out command reading stdin", got)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("timed out command with stdin returned after %s", elapsed)
+	}
+
+	res = h.callTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "true",
+		"env":          map[string]any{"NOT VALID": "x"},
+	})
+	if !res.IsError || !strings.Contains(resultText(res), "invalid environment variable name") {
+		t.Errorf("invalid env: got %q", resultText(res))
+	}
+
+	// Stdin up to the size limit is passed to the command, run directly or as a job, whatever it contains.
+	input := strings.Repeat("it's a 'quoted' line\n", (bitrise.MaxCommandBytes-1024)/21)
+	for _, meta := range []map[string]any{nil, {"progressToken": 4}} {
+		res = h.callToolWithMeta("bitrise_remote_machine_execute", map[string]any{
+			"machine_id":      id,
+			"bash_command":    "wc -c",
+			"stdin":           input,
+			"timeout_seconds": 60,
+		}, meta)
+		if got := commandResult(t, res); strings.TrimSpace(got.Stdout) != strconv.Itoa(len(input)) {
+			t.Errorf("with meta %v: got %+v, want the size of the large stdin", meta, got)
+		}
+	}
+	res = h.callTool("bitrise_remote_machine_execute", map[string]any{
+		"machine_id":   id,
+		"bash_command": "wc -c",
+		"stdin":        strings.Repeat("x", bitrise.MaxCommandBytes+1),
+	})
+	if !res.IsError || !strings.Contains(resultText(res), "more than the limit") {
+		t.Errorf("too large stdin: got %q", resultText(res))
+	}
+}
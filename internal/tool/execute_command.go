@@ -2,8 +2,8 @@ package tool
 
 import (
 	"context"
-	"fmt"
-	"net/http"
+	"strings"
+	"time"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -34,57 +34,78 @@ CRITICAL - DO NOT USE GIT CLONE FOR USER PROJECTS:
 COMMAND EXECUTION:
 - Commands are passed to "bash -c" for execution in a macOS shell environment.
 - The VM has standard macOS development tools available (Xcode, Git, Homebrew, etc.).
-- Commands are executed synchronously - the response contains the complete output.
-- NOTE: If the VM was just created, the first command may take extra time while the VM finishes booting.
-  The call will automatically wait for the VM to be ready before executing - this is normal behavior.
-
-CRITICAL - PREVENTING COMMAND HANGS:
-- Your command is executed inside "bash -c '<your_command>'" and the server listens on stdout and stderr.
-- If a program writes to stdout/stderr and doesn't close them, the server will wait forever and the tool will hang.
-- SOLUTION: For any long-running or background process, redirect BOTH stdout and stderr to /dev/null:
-  - Format: command >/dev/null 2>&1 &
-  - Example: bash_command="some_server >/dev/null 2>&1 &"
-  - Example: bash_command="open -a Xcode >/dev/null 2>&1"
-  - Example: bash_command="open ~/SomeApp.app >/dev/null 2>&1"
-- CRITICAL: You MUST redirect BOTH stdout (>) AND stderr (2>&1) or the command will hang.
-- FORBIDDEN patterns (will hang without redirection):
+- Commands are executed synchronously - the response contains the complete output, with stdout and stderr separated.
+- NOTE: Commands can't run until the VM has booted. If the VM was just created, or its state is provisioning
+  or booting, call bitrise_remote_machine_wait_for_ready first (or create it with wait=true).
+
+LONG-RUNNING AND BACKGROUND COMMANDS:
+- This tool waits until the command exits, and the call fails if that takes too long.
+- For builds, test runs, archives, servers and anything else that may run for more than a few minutes
+  or doesn't exit on its own, use bitrise_remote_machine_job_start instead and follow its output
+  with bitrise_remote_machine_job_output. Jobs keep their own output, they need no "&" or "2>&1".
+- FORBIDDEN patterns with this tool (they never exit):
   - "tail -f <file>" - must use "tail -n 100 <file>" instead (reads N lines then exits)
   - "watch <command>" - loops forever
-  - "cat" without arguments - waits for stdin
   - Interactive commands: "vim", "nano", "less", "top", "htop"
-  - Servers without redirection: "python -m http.server", "npm start", "rails server"
-  - GUI apps without redirection: "open -a SomeApp"
+  - Servers in the foreground: "python -m http.server", "npm start", "rails server" - start them as jobs
 - SAFE patterns:
   - Short-lived commands: "ls -la", "ps aux", "cat <filename>"
-  - Commands with redirection: "some_server >/dev/null 2>&1 &"
-  - Commands with timeout: "timeout 60 <command>"
-- ALWAYS ask yourself: "Will this command run in the background or keep stdout/stderr open?" If yes, add >/dev/null 2>&1
+  - Quick commands that open apps: "open -a Xcode"
+  - Commands that may get stuck, with timeout_seconds set
 - NEVER run osascript or AppleScript commands. ALAWAYS use click, type and similar tools instead.
 
 PARAMETERS:
 - machine_id (required): The VM to execute the command on.
 - bash_command (required): The command string to pass to bash -c for execution
   (e.g., "ls -la /Users", "xcodebuild -project MyApp.xcodeproj -scheme MyApp build").
+- cwd (optional): The directory to run the command in. Defaults to the user's home directory.
+  Use this instead of prefixing the command with "cd <dir> &&".
+- env (optional): Environment variables to set for the command, as an object of names and string values
+  (e.g., {"CONFIGURATION": "Release", "API_TOKEN": "..."}). Use this instead of "FOO=bar <command>"
+  to avoid quoting mistakes. Values of 4 or more characters are masked as *** in the result,
+  so secrets can be passed this way without being echoed back.
+- timeout_seconds (optional): Kill the command, and every process it started, after this many seconds
+  (at most 3600). A killed command has exit code 124 and timed_out set to true. Check timed_out, as the
+  command may exit with 124 by itself.
+- stdin (optional): Text to pass to the command on its standard input. Without it, stdin is empty,
+  so commands reading it see end of file instead of waiting forever. bash_command, stdin and env can be
+  at most 96 KiB together: upload larger input with bitrise_remote_machine_upload and redirect it from
+  the file instead (e.g. bash_command="patch -p1 < /Users/vagrant/fix.diff").
 
 EXAMPLE USAGE:
 - List files: bash_command="ls -la /Users"
 - Run xcodebuild: bash_command="xcodebuild -project MyApp.xcodeproj -scheme MyApp build"
 - Install package: bash_command="brew install jq"
-- Chain commands: bash_command="cd /path/to/project && make build"
+- Chain commands: bash_command="make build", cwd="/path/to/project"
+- Pass secrets: bash_command="fastlane beta", env={"FASTLANE_PASSWORD": "..."}
+- Bound the runtime: bash_command="xcrun simctl boot 'iPhone 16'", timeout_seconds=120
+- Feed input: bash_command="patch -p1", cwd="/Users/vagrant/MyApp", stdin="<diff content>"
 
 TYPICAL REMOTE BUILD WORKFLOW:
 1. bitrise_remote_machine_upload - upload local project to VM
-2. bitrise_remote_machine_execute - run build/test commands
+2. bitrise_remote_machine_execute - run quick commands, bitrise_remote_machine_job_start - run builds and tests
 3. bitrise_remote_machine_download - download build artifacts
 
 TIPS:
 - You can use shell features like pipes, redirects, and command chaining in bash_command.
 - For file operations, use absolute paths when possible.
-- Check command output for errors - non-zero exit codes indicate failures.
-- The working directory is typically the user's home directory unless changed.
-
-RETURNS: A JSON object containing 'output' (string) with the command's stdout/stderr.`,
+- Check exit_code and stderr for errors - non-zero exit codes indicate failures.
+- The working directory is the user's home directory unless cwd is set.
+
+RETURNS: A JSON object with:
+- exit_code: The exit code of the command (0 on success, -1 if it could not be determined).
+- stdout, stderr: The standard output and standard error of the command, separately.
+- duration_ms: How long the call took.
+- truncated: True if stdout or stderr was longer than the output limit. Only the beginning and the end
+  of long output are returned, with a "[... N bytes omitted ...]" marker in between.
+- timed_out: True if the command was killed because it ran longer than timeout_seconds.
+- command_id: The ID of the saved full output. Use bitrise_remote_machine_command_output with it
+  to page through or search (grep) the omitted output, instead of running the command again.
+The result is marked as an error if the exit code is not 0.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithOutputSchema[executeResult](),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to execute the command on"),
 			mcp.Required(),
@@ -93,7 +114,23 @@ RETURNS: A JSON object containing 'output' (string) with the command's stdout/st
 			mcp.Description("The command to pass to bash -c for execution (e.g., 'ls -la /Users', 'cd /project && make build')"),
 			mcp.Required(),
 		),
+		mcp.WithString("cwd",
+			mcp.Description("The directory on the VM to run the command in (defaults to the user's home directory)"),
+		),
+		mcp.WithObject("env",
+			mcp.Description("Environment variables to set for the command; their values are masked in the result"),
+			mcp.AdditionalProperties(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("Kill the command and the processes it started after this many seconds"),
+			mcp.Min(1),
+			mcp.Max(3600),
+		),
+		mcp.WithString("stdin",
+			mcp.Description("Text to pass to the command on its standard input (empty by default)"),
+		),
 	),
+	Group: bitrise.GroupExec,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -105,19 +142,95 @@ RETURNS: A JSON object containing 'output' (string) with the command's stdout/st
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{
-			"bashCCommand": bashCommand,
+		env, err := stringMapArgument(request, "env")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		cmd := bitrise.Command{
+			Script:         bashCommand,
+			Dir:            request.GetString("cwd", ""),
+			Env:            env,
+			Stdin:          request.GetString("stdin", ""),
+			Timeout:        time.Duration(request.GetInt("timeout_seconds", 0)) * time.Second,
+			MaxOutputBytes: maxCapturedOutputBytes,
+		}
+		if err := cmd.Validate(); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/execute", machineID),
-			Body:    body,
-		})
+		machines := bitrise.Machines(ctx)
+		var res *bitrise.CommandResult
+		if progress := newProgressReporter(ctx, request); progress.enabled() {
+			// The client asked for progress: run the command as a job to stream its output while it runs.
+			streamer := &outputStreamer{progress: progress, logger: "bitrise_remote_machine_execute", mask: cmd.Mask}
+			res, err = machines.RunJob(ctx, machineID, cmd, func(stdout, stderr string) {
+				streamer.write(ctx, stdout, stderr)
+			})
+			streamer.flush(ctx)
+		} else {
+			res, err = machines.Run(ctx, machineID, cmd)
+		}
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to execute command", err), nil
+			return newToolResultAPIError("failed to execute command", err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		// Keep the full output, and return only its beginning and end.
+		settings := outputSettingsFromContext(ctx)
+		out := executeResult{CommandResult: *res}
+		if id, err := settings.log.save(ctx, machineID, bashCommand, res); err == nil {
+			out.CommandID = id
+		}
+		var stdoutCut, stderrCut bool
+		out.Stdout, stdoutCut = truncateMiddle(res.Stdout, settings.headBytes, settings.tailBytes, out.CommandID)
+		out.Stderr, stderrCut = truncateMiddle(res.Stderr, settings.headBytes, settings.tailBytes, out.CommandID)
+		out.Truncated = res.Truncated || stdoutCut || stderrCut
+
+		result := mcp.NewToolResultStructuredOnly(out)
+		result.IsError = res.ExitCode != 0
+		return result, nil
 	},
 }
+
+// executeResult is the result of bitrise_remote_machine_execute.
+type executeResult struct {
+	bitrise.CommandResult
+	CommandID string `json:"command_id,omitempty" jsonschema:"description=ID of the saved full output\\, for bitrise_remote_machine_command_output"`
+}
+
+// outputStreamer forwards the output of a running command to the client line
+// by line, as progress notifications and info level log messages.
+type outputStreamer struct {
+	progress       *progressReporter
+	logger         string
+	mask           func(string) string
+	stdout, stderr lineBuffer
+	// sent is the number of bytes sent so far, used as the progress value.
+	sent int
+}
+
+func (s *outputStreamer) write(ctx context.Context, stdout, stderr string) {
+	s.send(ctx, "stdout", s.stdout.write(stdout))
+	s.send(ctx, "stderr", s.stderr.write(stderr))
+}
+
+// flush sends the last lines that didn't end with a newline.
+func (s *outputStreamer) flush(ctx context.Context) {
+	s.send(ctx, "stdout", s.stdout.flush())
+	s.send(ctx, "stderr", s.stderr.flush())
+}
+
+func (s *outputStreamer) send(ctx context.Context, stream string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	for i, line := range lines {
+		lines[i] = s.mask(line)
+	}
+	text := strings.Join(lines, "\n")
+	s.sent += len(text) + 1
+	message := text
+	if stream == "stderr" {
+		message = "[stderr] " + strings.Join(lines, "\n[stderr] ")
+	}
+	s.progress.report(ctx, float64(s.sent), 0, message)
+	logToClient(ctx, mcp.LoggingLevelInfo, s.logger, map[string]any{"stream": stream, "output": text})
+}
@@ -0,0 +1,402 @@
+package tool
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxReportedFiles is how many per-file outcomes of a download are listed in the result.
+const maxReportedFiles = 100
+
+// extractLimits limit what a downloaded archive may extract. Limits below 1
+// are disabled.
+type extractLimits struct {
+	// maxBytes is the total size of the extracted files.
+	maxBytes int64
+	// maxFiles is the number of extracted files, folders and symlinks.
+	maxFiles int
+	// maxFileBytes is the size of a single file.
+	maxFileBytes int64
+}
+
+var errExtractLimit = errors.New("download limit exceeded")
+
+// conflictPolicy decides what happens to a downloaded file or symlink whose
+// path already exists locally.
+type conflictPolicy string
+
+const (
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictSkip      conflictPolicy = "skip"
+	conflictRename    conflictPolicy = "rename"
+	conflictFail      conflictPolicy = "fail"
+)
+
+// The outcomes of the extracted files.
+const (
+	outcomeCreated     = "created"
+	outcomeOverwritten = "overwritten"
+	outcomeSkipped     = "skipped"
+	outcomeRenamed     = "renamed"
+	// outcomeConflict is a file a download with on_conflict fail would fail
+	// at, only reported by dry runs.
+	outcomeConflict = "conflict"
+)
+
+// fileOutcome is what happened, or would happen in a dry run, to a file or
+// symlink of a downloaded archive.
+type fileOutcome struct {
+	// path is slash separated and relative to the destination.
+	path    string
+	outcome string
+	// renamedTo is the path the file was written to instead, if it was renamed.
+	renamedTo string
+}
+
+// extraction extracts a downloaded archive. The zero value overwrites
+// existing files and has no limits.
+type extraction struct {
+	limits     extractLimits
+	onConflict conflictPolicy
+	// dryRun decides what would happen to the files, without writing anything.
+	dryRun   bool
+	progress *transferProgress
+
+	// files and bytes count the regular files of the archive.
+	files int
+	bytes int64
+	// outcomes are the outcomes of the files and symlinks, in archive order.
+	outcomes []fileOutcome
+	// created are the paths that didn't exist before, in the order they were
+	// created, to remove them if the extraction fails.
+	created []string
+	// backups are the files and symlinks the extraction replaced, moved aside
+	// to restore them if the extraction fails.
+	backups []backup
+}
+
+// backup is a replaced file or symlink, moved aside.
+type backup struct {
+	path    string
+	movedTo string
+}
+
+// extractTarGz extracts the tar.gz archive read from r in destPath while it
+// is read, and returns the extracted paths.
+//
+// The size of every file is checked against the limits before it is
+// extracted, so an archive that decompresses to much more than its size can't
+// fill the disk. If the extraction fails, the files and folders it created are
+// removed and the ones it replaced are restored, so the destination is left
+// as it was. Files are written next to their target and renamed once
+// complete, so existing files are never left half overwritten.
+func (x *extraction) extractTarGz(r io.Reader, destPath string) (paths []string, err error) {
+	defer func() {
+		if err != nil {
+			x.cleanup()
+		} else {
+			x.removeBackups()
+		}
+	}()
+
+	var extractedPaths []string
+	destPath = filepath.Clean(destPath)
+	if err := x.mkdirAll(destPath, 0755); err != nil {
+		return nil, fmt.Errorf("create destination directory: %w", err)
+	}
+
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("create gzip reader: %w", err)
+	}
+	defer gzReader.Close()
+
+	var entries int
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar header: %w", err)
+		}
+
+		// Skip macOS AppleDouble files (extended attributes stored as ._filename)
+		baseName := filepath.Base(header.Name)
+		if strings.HasPrefix(baseName, "._") {
+			continue
+		}
+
+		targetPath := filepath.Join(destPath, filepath.Clean(header.Name))
+
+		// Security: ensure target is within destination
+		if !isWithinDir(targetPath, destPath) {
+			return nil, fmt.Errorf("path traversal detected: %s", header.Name)
+		}
+
+		entries++
+		if x.limits.maxFiles > 0 && entries > x.limits.maxFiles {
+			return nil, fmt.Errorf("%w: the archive has more than %d files and folders", errExtractLimit, x.limits.maxFiles)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := x.mkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
+				return nil, fmt.Errorf("create directory %s: %w", targetPath, err)
+			}
+			extractedPaths = append(extractedPaths, targetPath)
+		case tar.TypeReg:
+			if x.limits.maxFileBytes > 0 && header.Size > x.limits.maxFileBytes {
+				return nil, fmt.Errorf("%w: %s is %d bytes, larger than %d bytes", errExtractLimit, header.Name, header.Size, x.limits.maxFileBytes)
+			}
+			x.files++
+			x.bytes += header.Size
+			if x.limits.maxBytes > 0 && x.bytes > x.limits.maxBytes {
+				return nil, fmt.Errorf("%w: the files of the archive are larger than %d bytes", errExtractLimit, x.limits.maxBytes)
+			}
+			targetPath, err := x.resolveConflict(targetPath, destPath)
+			if err != nil {
+				return nil, err
+			}
+			if targetPath == "" {
+				continue
+			}
+			if err := x.mkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return nil, fmt.Errorf("create parent directory: %w", err)
+			}
+			if err := x.extractFile(x.progress.tarReader(tarReader), targetPath, header.Mode); err != nil {
+				return nil, err
+			}
+			x.progress.addFile()
+			extractedPaths = append(extractedPaths, targetPath)
+		case tar.TypeSymlink:
+			// Validate symlink target, absolute targets always point outside of the destination
+			linkTarget := filepath.Join(filepath.Dir(targetPath), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || !isWithinDir(linkTarget, destPath) {
+				return nil, fmt.Errorf("symlink escapes destination: %s -> %s", header.Name, header.Linkname)
+			}
+			targetPath, err := x.resolveConflict(targetPath, destPath)
+			if err != nil {
+				return nil, err
+			}
+			if targetPath == "" {
+				continue
+			}
+			if err := x.mkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+				return nil, fmt.Errorf("create parent directory: %w", err)
+			}
+			if err := x.symlink(header.Linkname, targetPath); err != nil {
+				return nil, err
+			}
+			extractedPaths = append(extractedPaths, targetPath)
+		}
+	}
+	return extractedPaths, nil
+}
+
+// resolveConflict records the outcome of a file or symlink, and returns the
+// path to write it to, or an empty path if it is skipped.
+func (x *extraction) resolveConflict(targetPath, destPath string) (string, error) {
+	rel, _ := filepath.Rel(destPath, targetPath)
+	outcome := fileOutcome{path: filepath.ToSlash(rel), outcome: outcomeCreated}
+	if exists(targetPath) {
+		switch x.onConflict {
+		case conflictSkip:
+			outcome.outcome = outcomeSkipped
+			targetPath = ""
+		case conflictRename:
+			targetPath = renamedPath(targetPath)
+			rel, _ := filepath.Rel(destPath, targetPath)
+			outcome.outcome = outcomeRenamed
+			outcome.renamedTo = filepath.ToSlash(rel)
+		case conflictFail:
+			if x.dryRun {
+				outcome.outcome = outcomeConflict
+				targetPath = ""
+				break
+			}
+			return "", fmt.Errorf("%s already exists in the destination and on_conflict is %q", outcome.path, conflictFail)
+		default:
+			outcome.outcome = outcomeOverwritten
+		}
+	}
+	x.outcomes = append(x.outcomes, outcome)
+	return targetPath, nil
+}
+
+// renamedPath returns the first path like "foo (1).ipa" next to path that doesn't exist.
+func renamedPath(path string) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		// A dotfile, like .env, has no extension.
+		stem, ext = base, ""
+	}
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		if !exists(candidate) {
+			return candidate
+		}
+	}
+}
+
+func isWithinDir(path, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// mkdirAll creates a folder with its missing parents, like os.MkdirAll.
+func (x *extraction) mkdirAll(dir string, mode os.FileMode) error {
+	if x.dryRun {
+		return nil
+	}
+	var missing []string
+	for d := dir; !exists(d); d = filepath.Dir(d) {
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	for _, d := range slices.Backward(missing) {
+		x.created = append(x.created, d)
+	}
+	return nil
+}
+
+// extractFile writes the content read from r to a temporary file next to
+// targetPath, and renames it to targetPath once it is complete.
+func (x *extraction) extractFile(r io.Reader, targetPath string, mode int64) error {
+	if x.dryRun {
+		return nil
+	}
+	file, err := os.CreateTemp(filepath.Dir(targetPath), "."+filepath.Base(targetPath)+".download-*")
+	if err != nil {
+		return fmt.Errorf("create file %s: %w", targetPath, err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write file %s: %w", targetPath, err)
+	}
+	if err := os.Chmod(file.Name(), os.FileMode(mode).Perm()); err != nil {
+		return fmt.Errorf("set mode of %s: %w", targetPath, err)
+	}
+
+	existed := exists(targetPath)
+	if existed {
+		if err := x.backup(targetPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(file.Name(), targetPath); err != nil {
+		return fmt.Errorf("create file %s: %w", targetPath, err)
+	}
+	if !existed {
+		x.created = append(x.created, targetPath)
+	}
+	return nil
+}
+
+// symlink creates a symlink at targetPath, replacing what is there.
+func (x *extraction) symlink(linkname, targetPath string) error {
+	if x.dryRun {
+		return nil
+	}
+	existed := exists(targetPath)
+	if existed {
+		if err := x.backup(targetPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink(linkname, targetPath); err != nil {
+		return fmt.Errorf("create symlink: %w", err)
+	}
+	if !existed {
+		x.created = append(x.created, targetPath)
+	}
+	return nil
+}
+
+// backup moves the file or symlink at path aside, so it can be restored if
+// the extraction fails.
+func (x *extraction) backup(path string) error {
+	// Reserve a free name next to the file, which the rename replaces.
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".backup-*")
+	if err != nil {
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
+	file.Close()
+	if err := os.Rename(path, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
+	x.backups = append(x.backups, backup{path: path, movedTo: file.Name()})
+	return nil
+}
+
+// cleanup removes the files and folders the extraction created, and restores
+// the ones it replaced.
+func (x *extraction) cleanup() {
+	for _, path := range slices.Backward(x.created) {
+		os.RemoveAll(path)
+	}
+	for _, b := range slices.Backward(x.backups) {
+		os.Remove(b.path)
+		os.Rename(b.movedTo, b.path)
+	}
+}
+
+// removeBackups removes the replaced files once the extraction succeeded.
+func (x *extraction) removeBackups() {
+	for _, b := range x.backups {
+		os.Remove(b.movedTo)
+	}
+}
+
+// report lists the outcomes of the files, with the number of files per outcome.
+func (x *extraction) report() string {
+	counts := make(map[string]int)
+	for _, o := range x.outcomes {
+		counts[o.outcome]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d created, %d overwritten, %d renamed, %d skipped",
+		counts[outcomeCreated], counts[outcomeOverwritten], counts[outcomeRenamed], counts[outcomeSkipped])
+	if counts[outcomeConflict] > 0 {
+		fmt.Fprintf(&b, ", %d conflicting", counts[outcomeConflict])
+	}
+	b.WriteString(":")
+	for i, o := range x.outcomes {
+		if i == maxReportedFiles {
+			fmt.Fprintf(&b, "\n... and %d more", len(x.outcomes)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\n- %s %s", o.outcome, o.path)
+		if o.renamedTo != "" {
+			fmt.Fprintf(&b, " -> %s", o.renamedTo)
+		}
+	}
+	return b.String()
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,125 @@
+package tool
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  tar.Header
+		wantErr string
+	}{
+		{
+			name:    "path traversal",
+			header:  tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+			wantErr: "path traversal",
+		},
+		{
+			name:    "nested path traversal",
+			header:  tar.Header{Name: "a/../../evil.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+			wantErr: "path traversal",
+		},
+		{
+			name:    "relative symlink out of the destination",
+			header:  tar.Header{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"},
+			wantErr: "symlink escapes destination",
+		},
+		{
+			name:    "absolute symlink",
+			header:  tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			wantErr: "symlink escapes destination",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "dest")
+			_, err := (&extraction{}).extractTarGz(bytes.NewReader(tarGz(t, tt.header)), dest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil.txt")); !os.IsNotExist(err) {
+				t.Error("file was written outside the destination")
+			}
+		})
+	}
+}
+
+func TestExtractSkipsAppleDouble(t *testing.T) {
+	dest := t.TempDir()
+	data := tarGz(t,
+		tar.Header{Name: "app/._Info.plist", Typeflag: tar.TypeReg, Mode: 0o644},
+		tar.Header{Name: "app/Info.plist", Typeflag: tar.TypeReg, Mode: 0o644},
+	)
+	paths, err := (&extraction{}).extractTarGz(bytes.NewReader(data), dest)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "Info.plist" {
+		t.Errorf("extracted %v, want only Info.plist", paths)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, src, map[string]string{
+		"a.bin":     strings.Repeat("a", 1000),
+		"b.bin":     strings.Repeat("b", 1000),
+		"sub/c.txt": "c",
+	})
+	data := mustCreateTarGz(t, src, false)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		limits  extractLimits
+		wantErr string
+	}{
+		{name: "too many files", data: data, limits: extractLimits{maxFiles: 2}, wantErr: "more than 2 files and folders"},
+		{name: "too large file", data: data, limits: extractLimits{maxFileBytes: 999}, wantErr: "larger than 999 bytes"},
+		{name: "too large archive", data: data, limits: extractLimits{maxBytes: 1500}, wantErr: "larger than 1500 bytes"},
+		{name: "truncated archive", data: data[:len(data)/2], wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			writeFiles(t, dest, map[string]string{"keep.txt": "keep", "app/a.bin": "old"})
+
+			_, err := (&extraction{limits: tt.limits}).extractTarGz(bytes.NewReader(tt.data), dest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			var left []string
+			_ = filepath.WalkDir(dest, func(path string, d os.DirEntry, _ error) error {
+				if !d.IsDir() {
+					rel, _ := filepath.Rel(dest, path)
+					left = append(left, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			if want := []string{"app/a.bin", "keep.txt"}; !slices.Equal(left, want) {
+				t.Errorf("left %v after the failed extraction, want %v", left, want)
+			}
+			// Files overwritten before the failure are restored.
+			assertFile(t, filepath.Join(dest, "app/a.bin"), "old")
+		})
+	}
+
+	dest := t.TempDir()
+	writeFiles(t, dest, map[string]string{"app/a.bin": "old"})
+	if _, err := (&extraction{limits: extractLimits{maxBytes: 2001, maxFiles: 5, maxFileBytes: 1000}}).extractTarGz(bytes.NewReader(data), dest); err != nil {
+		t.Fatalf("extract within the limits: %v", err)
+	}
+	assertFile(t, filepath.Join(dest, "app/a.bin"), strings.Repeat("a", 1000))
+	assertFile(t, filepath.Join(dest, "app/b.bin"), strings.Repeat("b", 1000))
+	if backups, _ := filepath.Glob(filepath.Join(dest, "app", ".*.backup-*")); len(backups) != 0 {
+		t.Errorf("backups of the overwritten files were not removed: %v", backups)
+	}
+}
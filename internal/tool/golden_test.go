@@ -0,0 +1,69 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestToolContracts compares the definition of every tool (name, description,
+// input schema and annotations) with its golden file, so changes to the
+// contracts prompts depend on are always deliberate. Run
+// `go test ./internal/tool -run TestToolContracts -update` to accept changes.
+func TestToolContracts(t *testing.T) {
+	belt := NewBelt()
+	dir := filepath.Join("testdata", "tools")
+
+	for name, tool := range belt.tools {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.MarshalIndent(tool.Definition, "", "  ")
+			if err != nil {
+				t.Fatalf("marshal definition: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join(dir, name+".json")
+			if *update {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("definition of %s differs from %s (run with -update if the change is intended)\ngot:\n%s", name, path, got)
+			}
+		})
+	}
+
+	// Every golden file must belong to a tool, so removed tools are noticed too.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read golden files: %v", err)
+	}
+	var names []string
+	for name := range belt.tools {
+		names = append(names, name+".json")
+	}
+	for _, entry := range entries {
+		if !slices.Contains(names, entry.Name()) {
+			if *update {
+				_ = os.Remove(filepath.Join(dir, entry.Name()))
+				continue
+			}
+			t.Errorf("golden file %s has no tool (run with -update to remove it)", entry.Name())
+		}
+	}
+}
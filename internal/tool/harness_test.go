@@ -0,0 +1,316 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/fakeapi"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const testToken = "test-token"
+
+// harness drives an MCP server with the tool belt in-process, backed by a
+// fake Bitrise API, the same way a client would over a real transport.
+type harness struct {
+	t       *testing.T
+	fake    *fakeapi.Server
+	server  *server.MCPServer
+	belt    *Belt
+	client  *bitrise.Client
+	session *harnessSession
+	ctx     context.Context
+	nextID  int
+}
+
+// harnessSession is an in-process session whose notifications can be read by the test.
+type harnessSession struct {
+	*server.InProcessSession
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *harnessSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+// harnessOption configures a harness.
+type harnessOption func(*harnessConfig)
+
+type harnessConfig struct {
+	beltOpts []BeltOption
+	fakeOpts []fakeapi.Option
+	groups   []string
+	ctx      func(context.Context) context.Context
+	api      func(http.Handler) http.Handler
+}
+
+func withBeltOptions(opts ...BeltOption) harnessOption {
+	return func(c *harnessConfig) {
+		c.beltOpts = append(c.beltOpts, opts...)
+	}
+}
+
+func withFakeOptions(opts ...fakeapi.Option) harnessOption {
+	return func(c *harnessConfig) {
+		c.fakeOpts = append(c.fakeOpts, opts...)
+	}
+}
+
+func withRegisteredGroups(groups ...string) harnessOption {
+	return func(c *harnessConfig) {
+		c.groups = groups
+	}
+}
+
+// withAPIHandler wraps the fake API, e.g. to simulate endpoints the real API
+// doesn't have.
+func withAPIHandler(fn func(http.Handler) http.Handler) harnessOption {
+	return func(c *harnessConfig) {
+		c.api = fn
+	}
+}
+
+// withSessionContext modifies the context of every request, like the HTTP
+// context func does with the request headers.
+func withSessionContext(fn func(context.Context) context.Context) harnessOption {
+	return func(c *harnessConfig) {
+		c.ctx = fn
+	}
+}
+
+func newHarness(t *testing.T, opts ...harnessOption) *harness {
+	t.Helper()
+	cfg := harnessConfig{
+		beltOpts: []BeltOption{WithCommandLogDir(t.TempDir()), WithSyncManifestDir(t.TempDir())},
+		ctx:      func(ctx context.Context) context.Context { return ctx },
+		api:      func(h http.Handler) http.Handler { return h },
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	fake, err := fakeapi.New(append([]fakeapi.Option{fakeapi.WithRootDir(t.TempDir()), fakeapi.WithToken(testToken)}, cfg.fakeOpts...)...)
+	if err != nil {
+		t.Fatalf("start fake API: %v", err)
+	}
+	ts := httptest.NewServer(cfg.api(fake))
+	t.Cleanup(func() {
+		ts.Close()
+		if err := fake.Close(); err != nil {
+			t.Errorf("close fake API: %v", err)
+		}
+	})
+	client := bitrise.NewClient(
+		bitrise.WithBaseURL(ts.URL),
+		bitrise.WithBackoff(time.Millisecond, 10*time.Millisecond),
+	)
+
+	belt := NewBelt(cfg.beltOpts...)
+	hooks := &server.Hooks{}
+	belt.RegisterHooks(hooks)
+	mcpServer := server.NewMCPServer("bitrise", "test",
+		server.WithToolCapabilities(false),
+		server.WithResourceCapabilities(true, false),
+		server.WithLogging(),
+		server.WithHooks(hooks),
+	)
+	if err := belt.RegisterGroups(mcpServer, cfg.groups); err != nil {
+		t.Fatalf("register tools: %v", err)
+	}
+	server.WithToolFilter(belt.ToolFilter)(mcpServer)
+	server.WithToolHandlerMiddleware(belt.Middleware)(mcpServer)
+	server.WithToolHandlerMiddleware(func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			ctx = bitrise.ContextWithClient(ctx, client)
+			return next(bitrise.ContextWithPAT(ctx, testToken), request)
+		}
+	})(mcpServer)
+	server.WithResourceHandlerMiddleware(belt.ResourceMiddleware)(mcpServer)
+	server.WithResourceHandlerMiddleware(func(next server.ResourceHandlerFunc) server.ResourceHandlerFunc {
+		return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			ctx = bitrise.ContextWithClient(ctx, client)
+			return next(bitrise.ContextWithPAT(ctx, testToken), request)
+		}
+	})(mcpServer)
+
+	session := &harnessSession{
+		InProcessSession: server.NewInProcessSession("test-session", nil),
+		notifications:    make(chan mcp.JSONRPCNotification, 1000),
+	}
+	ctx := context.Background()
+	if err := mcpServer.RegisterSession(ctx, session); err != nil {
+		t.Fatalf("register session: %v", err)
+	}
+	t.Cleanup(func() { mcpServer.UnregisterSession(ctx, session.SessionID()) })
+
+	h := &harness{
+		t:       t,
+		fake:    fake,
+		server:  mcpServer,
+		belt:    belt,
+		client:  client,
+		session: session,
+		ctx:     cfg.ctx(mcpServer.WithContext(ctx, session)),
+	}
+	h.request(mcp.MethodInitialize, mcp.InitializeParams{
+		ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
+		ClientInfo:      mcp.Implementation{Name: "harness", Version: "test"},
+	}, nil)
+	return h
+}
+
+// request sends a JSON-RPC request and decodes its result into out.
+func (h *harness) request(method mcp.MCPMethod, params any, out any) {
+	h.t.Helper()
+	result, err := h.send(method, params)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if out == nil {
+		return
+	}
+	if err := json.Unmarshal(result, out); err != nil {
+		h.t.Fatalf("unmarshal %s result: %v", method, err)
+	}
+}
+
+// send sends a JSON-RPC request and returns its raw result, or its error.
+func (h *harness) send(method mcp.MCPMethod, params any) (json.RawMessage, error) {
+	h.t.Helper()
+	h.nextID++
+	raw, err := json.Marshal(map[string]any{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"id":      h.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		h.t.Fatalf("marshal %s request: %v", method, err)
+	}
+
+	// Subscriptions are handled by the transports, like in main.
+	var response any
+	if IsSubscriptionRequest(raw) {
+		ctx := bitrise.ContextWithPAT(bitrise.ContextWithClient(h.ctx, h.client), testToken)
+		response = h.belt.HandleSubscription(ctx, h.session.SessionID(), raw)
+	} else {
+		response = h.server.HandleMessage(h.ctx, raw)
+	}
+	resp, err := json.Marshal(response)
+	if err != nil {
+		h.t.Fatalf("marshal %s response: %v", method, err)
+	}
+	var msg struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &msg); err != nil {
+		h.t.Fatalf("unmarshal %s response: %v", method, err)
+	}
+	if msg.Error != nil {
+		return nil, fmt.Errorf("%s failed: %d %s", method, msg.Error.Code, msg.Error.Message)
+	}
+	return msg.Result, nil
+}
+
+// readResource reads a resource, and returns its contents or the error of the request.
+func (h *harness) readResource(uri string) ([]mcp.ResourceContents, error) {
+	h.t.Helper()
+	raw, err := h.send(mcp.MethodResourcesRead, map[string]any{"uri": uri})
+	if err != nil {
+		return nil, err
+	}
+	res, err := mcp.ParseReadResourceResult(&raw)
+	if err != nil {
+		h.t.Fatalf("parse read result of %s: %v", uri, err)
+	}
+	return res.Contents, nil
+}
+
+func (h *harness) listTools() []mcp.Tool {
+	h.t.Helper()
+	var res mcp.ListToolsResult
+	h.request(mcp.MethodToolsList, map[string]any{}, &res)
+	return res.Tools
+}
+
+// callTool calls a tool and returns its result; tool errors are returned, not reported.
+func (h *harness) callTool(name string, args map[string]any) *mcp.CallToolResult {
+	h.t.Helper()
+	return h.callToolWithMeta(name, args, nil)
+}
+
+func (h *harness) callToolWithMeta(name string, args map[string]any, meta map[string]any) *mcp.CallToolResult {
+	h.t.Helper()
+	params := map[string]any{"name": name, "arguments": args}
+	if meta != nil {
+		params["_meta"] = meta
+	}
+	var raw json.RawMessage
+	h.request(mcp.MethodToolsCall, params, &raw)
+	res, err := mcp.ParseCallToolResult(&raw)
+	if err != nil {
+		h.t.Fatalf("parse %s result: %v", name, err)
+	}
+	return res
+}
+
+// mustCallTool calls a tool and fails the test if it returns an error.
+func (h *harness) mustCallTool(name string, args map[string]any) *mcp.CallToolResult {
+	h.t.Helper()
+	res := h.callTool(name, args)
+	if res.IsError {
+		h.t.Fatalf("%s failed: %s", name, resultText(res))
+	}
+	return res
+}
+
+// notifications returns the notifications received so far.
+func (h *harness) notifications() []mcp.JSONRPCNotification {
+	var received []mcp.JSONRPCNotification
+	for {
+		select {
+		case n := <-h.session.notifications:
+			received = append(received, n)
+		default:
+			return received
+		}
+	}
+}
+
+// resultText joins the text contents of a tool result.
+func resultText(res *mcp.CallToolResult) string {
+	var text string
+	for _, content := range res.Content {
+		if tc, ok := mcp.AsTextContent(content); ok {
+			if text != "" {
+				text += "\n"
+			}
+			text += tc.Text
+		}
+	}
+	return text
+}
+
+// createMachine creates a machine and returns its ID.
+func (h *harness) createMachine() string {
+	h.t.Helper()
+	var created bitrise.CreateMachineResponse
+	if err := json.Unmarshal([]byte(resultText(h.mustCallTool("bitrise_remote_machine_create", nil))), &created); err != nil {
+		h.t.Fatalf("unmarshal create result: %v", err)
+	}
+	if created.MachineID == "" {
+		h.t.Fatal("create returned no machine ID")
+	}
+	return created.MachineID
+}
@@ -0,0 +1,71 @@
+package tool
+
+import (
+	"context"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var JobCancel = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_job_cancel",
+		mcp.WithDescription(
+			`Cancel a background job started with bitrise_remote_machine_job_start by sending it a signal.
+
+PURPOSE:
+Stops a command that is stuck, runs longer than expected, or is no longer needed.
+The signal is sent to the whole process group of the job, so processes started by the command
+(e.g. the compiler processes of xcodebuild) are stopped too.
+
+PARAMETERS:
+- machine_id (required): The VM the job was started on.
+- job_id (required): The job_id returned by bitrise_remote_machine_job_start.
+- signal (optional): The signal to send: "TERM" (default), "INT", "HUP", "QUIT" or "KILL".
+  Use "INT" to stop a command like pressing Ctrl+C, and "KILL" only if the job ignores "TERM".
+
+IMPORTANT NOTES:
+- Canceling a job that already exited does nothing and returns its status.
+- The call waits a second for the job to stop. If the state is still "running", the command
+  handles the signal and may need more time, or another call with "KILL".
+
+RETURNS: The status of the job: job_id, state, exit_code, signal, started_at, duration_seconds,
+stdout_bytes and stderr_bytes.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[bitrise.JobStatus](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine the job runs on"),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job returned by bitrise_remote_machine_job_start"),
+			mcp.Required(),
+		),
+		mcp.WithString("signal",
+			mcp.Description("The signal to send to the job"),
+			mcp.Enum(bitrise.JobSignals()...),
+			mcp.DefaultString("TERM"),
+		),
+	),
+	Group: bitrise.GroupExec,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		signal := request.GetString("signal", "TERM")
+		status, err := bitrise.Machines(ctx).CancelJob(ctx, machineID, jobID, signal)
+		if err != nil {
+			return newToolResultAPIError("failed to cancel job", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(status), nil
+	},
+}
@@ -0,0 +1,87 @@
+package tool
+
+import (
+	"context"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var JobOutput = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_job_output",
+		mcp.WithDescription(
+			`Read new output of a background job started with bitrise_remote_machine_job_start, together with its state.
+
+PURPOSE:
+Poll this tool to follow a long-running command. Every call returns the output written since the given
+offsets and the offsets to continue from, so no output is returned twice.
+
+PARAMETERS:
+- machine_id (required): The VM the job was started on.
+- job_id (required): The job_id returned by bitrise_remote_machine_job_start.
+- stdout_offset (optional): The byte offset in stdout to read from. Use 0 (default) for the first call,
+  then the next_stdout_offset of the previous call.
+- stderr_offset (optional): The byte offset in stderr to read from, like stdout_offset.
+- max_bytes (optional): The maximum number of bytes to return from each stream. Defaults to 65536.
+
+WORKFLOW:
+1. Call with offsets 0.
+2. While state is "running", wait a bit and call again with next_stdout_offset and next_stderr_offset.
+3. Once state is not "running" and the next offsets equal stdout_bytes and stderr_bytes, all output was read.
+
+TIPS:
+- For very verbose commands (like xcodebuild), you may skip ahead by passing stdout_bytes minus a few
+  thousand as stdout_offset to only read the latest output.
+- Don't poll in a tight loop: long builds take minutes, wait between calls.
+
+RETURNS: The job status (job_id, state, exit_code, signal, started_at, duration_seconds, stdout_bytes,
+stderr_bytes) with stdout, stderr, next_stdout_offset and next_stderr_offset.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[bitrise.JobOutput](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine the job runs on"),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job returned by bitrise_remote_machine_job_start"),
+			mcp.Required(),
+		),
+		mcp.WithNumber("stdout_offset",
+			mcp.Description("The byte offset in stdout to read from (next_stdout_offset of the previous call)"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("stderr_offset",
+			mcp.Description("The byte offset in stderr to read from (next_stderr_offset of the previous call)"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("max_bytes",
+			mcp.Description("The maximum number of bytes to return from each stream"),
+			mcp.Min(1),
+		),
+	),
+	Group: bitrise.GroupExec,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		output, err := bitrise.Machines(ctx).ReadJobOutput(ctx, machineID, jobID, bitrise.JobOutputRequest{
+			StdoutOffset: int64(max(request.GetInt("stdout_offset", 0), 0)),
+			StderrOffset: int64(max(request.GetInt("stderr_offset", 0), 0)),
+			MaxBytes:     request.GetInt("max_bytes", bitrise.DefaultJobOutputBytes),
+		})
+		if err != nil {
+			return newToolResultAPIError("failed to read job output", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(output), nil
+	},
+}
@@ -0,0 +1,79 @@
+package tool
+
+import (
+	"context"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var JobStart = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_job_start",
+		mcp.WithDescription(
+			`Start a shell command in the background on a remote macOS virtual machine.
+
+PURPOSE:
+Use this instead of bitrise_remote_machine_execute for commands that run for a long time
+or don't exit on their own, like "xcodebuild archive", test suites, servers or simulators.
+The call returns immediately with a job_id while the command keeps running on the VM.
+
+PREREQUISITES:
+- You MUST have a running VM before calling this.
+- Call bitrise_remote_machine_list first to get an existing machine_id, or bitrise_remote_machine_create if none exists.
+
+HOW JOBS RUN:
+- The command is passed to "bash -c" in the user's home directory, like with bitrise_remote_machine_execute.
+- Its stdout and stderr are saved on the VM, there is NO need to redirect them or to append "&".
+- Stdin is empty, so commands waiting for input see end of file instead of hanging.
+- The job runs in its own process group, so canceling it also stops the processes it started.
+- Jobs are lost when the VM is deleted.
+
+WORKFLOW:
+1. bitrise_remote_machine_job_start - start the command and remember the job_id
+2. bitrise_remote_machine_job_output - poll the state and read new output from the returned offsets
+   until the state is not "running" anymore
+3. bitrise_remote_machine_job_cancel - stop the job if it's stuck or no longer needed
+
+PARAMETERS:
+- machine_id (required): The VM to start the job on.
+- bash_command (required): The command string to pass to bash -c
+  (e.g., "cd ~/MyApp && xcodebuild -scheme MyApp archive").
+
+CRITICAL - FILE TRANSFER RESTRICTIONS:
+- DO NOT use jobs to transfer files between local and remote machines.
+- Use bitrise_remote_machine_upload and bitrise_remote_machine_download instead.
+
+RETURNS: The status of the new job: job_id, state ("running", or "exited" if it finished already),
+exit_code once exited, started_at, duration_seconds, stdout_bytes and stderr_bytes.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithOutputSchema[bitrise.JobStatus](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to start the job on"),
+			mcp.Required(),
+		),
+		mcp.WithString("bash_command",
+			mcp.Description("The command to pass to bash -c in the background (e.g., 'cd ~/MyApp && xcodebuild -scheme MyApp archive')"),
+			mcp.Required(),
+		),
+	),
+	Group: bitrise.GroupExec,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		bashCommand, err := request.RequireString("bash_command")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		status, err := bitrise.Machines(ctx).StartJob(ctx, machineID, bitrise.Command{Script: bashCommand})
+		if err != nil {
+			return newToolResultAPIError("failed to start job", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(status), nil
+	},
+}
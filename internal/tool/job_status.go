@@ -0,0 +1,64 @@
+package tool
+
+import (
+	"context"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var JobStatus = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_job_status",
+		mcp.WithDescription(
+			`Get the state and exit code of a background job started with bitrise_remote_machine_job_start.
+
+STATES:
+- "running": The command is still running.
+- "exited": The command finished, exit_code holds its exit code (0 on success).
+- "canceled": The command was stopped by bitrise_remote_machine_job_cancel, signal holds the signal.
+- "lost": The command stopped without recording its exit code, e.g. it was killed by another process.
+
+PARAMETERS:
+- machine_id (required): The VM the job was started on.
+- job_id (required): The job_id returned by bitrise_remote_machine_job_start.
+
+TIPS:
+- Use bitrise_remote_machine_job_output to get the state together with the new output,
+  this tool is for checking the state only.
+- Don't poll in a tight loop: long builds take minutes, wait between calls.
+
+RETURNS: job_id, state, exit_code (once exited), signal (if canceled), started_at,
+duration_seconds, stdout_bytes and stderr_bytes.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[bitrise.JobStatus](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine the job runs on"),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job returned by bitrise_remote_machine_job_start"),
+			mcp.Required(),
+		),
+	),
+	Group: bitrise.GroupExec,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		status, err := bitrise.Machines(ctx).GetJobStatus(ctx, machineID, jobID)
+		if err != nil {
+			return newToolResultAPIError("failed to get job status", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(status), nil
+	},
+}
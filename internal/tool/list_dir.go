@@ -0,0 +1,140 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultListEntries is how many entries bitrise_remote_machine_list_dir returns by default.
+const defaultListEntries = 1000
+
+// maxListEntries is the most entries bitrise_remote_machine_list_dir returns.
+const maxListEntries = 5000
+
+var ListDir = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_list_dir",
+		mcp.WithDescription(
+			`List the files and folders of a folder on the remote macOS virtual machine.
+
+PURPOSE:
+Use this tool to explore the file system of the VM (e.g. find the build products in DerivedData, or check
+what an upload created) as structured data, without running ls with bitrise_remote_machine_execute.
+
+PARAMETERS:
+- machine_id (required): The VM to list the folder on.
+- path (required): The path of the folder on the VM. Relative paths are relative to the home directory.
+- max_entries (optional): The maximum number of entries to return, up to 5000. Defaults to 1000.
+
+NOTES:
+- Hidden files are listed too. The list is not recursive: call the tool again for subfolders.
+- Entries are sorted by name. If the folder has more entries than max_entries, truncated is true.
+- Symlinks are not followed: they are listed with their type "symlink" and their link_target.
+
+RETURNS: path, entries (each with name, type, size, mode, mod_time and link_target) and truncated.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[listDirResult](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to list the folder on"),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("The path of the folder on the VM, relative to the home directory if not absolute"),
+			mcp.Required(),
+		),
+		mcp.WithNumber("max_entries",
+			mcp.Description("The maximum number of entries to return"),
+			mcp.Min(1),
+			mcp.Max(maxListEntries),
+		),
+	),
+	Group: bitrise.GroupTransfer,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		dirPath, err := request.RequireString("path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		maxEntries := min(max(request.GetInt("max_entries", defaultListEntries), 1), maxListEntries)
+		result, err := listRemoteDir(ctx, machineID, dirPath, maxEntries)
+		var fileErr *remoteFileError
+		if errors.As(err, &fileErr) {
+			return mcp.NewToolResultError(fileErr.Error()), nil
+		}
+		if err != nil {
+			return newToolResultAPIError("failed to list folder", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(result), nil
+	},
+}
+
+// listDirResult is the result of bitrise_remote_machine_list_dir.
+type listDirResult struct {
+	Path      string           `json:"path" jsonschema:"description=The path of the folder as it was requested"`
+	Entries   []remoteFileInfo `json:"entries" jsonschema:"description=The files and folders in the folder\\, sorted by name"`
+	Truncated bool             `json:"truncated" jsonschema:"description=True if the folder has more entries than max_entries"`
+}
+
+// listRemoteDir returns at most maxEntries entries of a folder on the remote
+// machine, sorted by name.
+func listRemoteDir(ctx context.Context, machineID, dirPath string, maxEntries int) (listDirResult, error) {
+	res, err := bitrise.Machines(ctx).Run(ctx, machineID, bitrise.Command{
+		// One more entry than asked for tells whether the list is truncated.
+		Script:         listDirScript(dirPath, maxEntries+1),
+		MaxOutputBytes: (maxListEntries + 1) * 512,
+	})
+	if err != nil {
+		return listDirResult{}, err
+	}
+	switch res.ExitCode {
+	case 0:
+	case fileErrorExitCode:
+		return listDirResult{}, newRemoteFileError("failed to list %s: %s", dirPath, strings.TrimSpace(res.Stderr))
+	default:
+		return listDirResult{}, newRemoteFileError("failed to list %s: exit code %d: %s", dirPath, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+
+	result := listDirResult{Path: dirPath, Entries: []remoteFileInfo{}}
+	for line := range strings.Lines(res.Stdout) {
+		info, err := parseStatEntry(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			// Names with line breaks can't be parsed, they are left out.
+			continue
+		}
+		if len(result.Entries) == maxEntries {
+			result.Truncated = true
+			break
+		}
+		result.Entries = append(result.Entries, info)
+	}
+	slices.SortFunc(result.Entries, func(a, b remoteFileInfo) int { return strings.Compare(a.Name, b.Name) })
+	return result, nil
+}
+
+// listDirScript returns a script that prints the first limit entries of the
+// folder by name. The names are sorted bytewise, like strings.Compare, before
+// the list is cut, so a truncated list is the start of the full one.
+func listDirScript(dirPath string, limit int) string {
+	return fmt.Sprintf(`d=%s
+if [ ! -e "$d" ]; then echo "no such file or directory" >&2; exit %[2]d; fi
+if [ ! -d "$d" ]; then echo "not a directory" >&2; exit %[2]d; fi
+%[3]sn=0
+ls -A -- "$d" | LC_ALL=C sort | while IFS= read -r f; do
+  [ -e "$d/$f" ] || [ -L "$d/$f" ] || continue
+  n=$((n+1)); [ $n -gt %[4]d ] && break
+  entry "$d/$f" "$f"
+done`, bitrise.ShellQuote(remoteShellPath(dirPath)), fileErrorExitCode, statScript, limit)
+}
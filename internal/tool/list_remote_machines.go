@@ -2,7 +2,7 @@ package tool
 
 import (
 	"context"
-	"net/http"
+	"time"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -11,7 +11,8 @@ import (
 var ListRemoteMachines = bitrise.Tool{
 	Definition: mcp.NewTool("bitrise_remote_machine_list",
 		mcp.WithDescription(
-			`List all remote macOS virtual machines currently running for the authenticated user.
+			`List all remote macOS virtual machines currently running for the authenticated user, with their state
+and details.
 
 THIS SHOULD BE YOUR FIRST CALL when you need to execute commands on a VM.
 
@@ -23,24 +24,49 @@ WHY CALL THIS FIRST:
 
 DECISION FLOW:
 1. Call bitrise_remote_machine_list
-2. If machine_ids array is NOT empty: use the existing machine_id for subsequent operations
-3. If machine_ids array IS empty: call bitrise_remote_machine_create to provision a new VM
+2. If machines is NOT empty: use the existing machine_id for subsequent operations. If its state is
+   provisioning or booting, commands can't be used yet: call bitrise_remote_machine_wait_for_ready first.
+   If its state is terminating, create a new one.
+3. If machines IS empty: call bitrise_remote_machine_create to provision a new VM
 
-RETURNS: A JSON object containing 'machine_ids' (array of strings).
-- Empty array [] means no VMs are running - you need to create one.
-- Array with one ID means a VM exists - use that machine_id for operations.`,
+RETURNS: machine_ids (array of strings), and machines with the details of every VM: machine_id, state
+(provisioning, booting, ready, terminating, or unknown with an error if the details couldn't be fetched),
+created_at, expires_at, remaining_minutes, macos_version, xcode_version, ip_address and vnc_available.
+See bitrise_remote_machine_describe for the details of one VM. A machine in the unknown state is still
+running: reuse it, don't create a new one.
+- Empty arrays mean no VMs are running - you need to create one.
+- One entry means a VM exists - use that machine_id for operations.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[listMachinesResult](),
 	),
+	Group: bitrise.GroupLifecycle,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodGet,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    "/platform/me/machines",
-		})
-
+		res, err := bitrise.Machines(ctx).List(ctx)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to list remote machines", err), nil
+			return newToolResultAPIError("failed to list remote machines", err), nil
+		}
+
+		result := listMachinesResult{MachineIDs: []string{}, Machines: []machineDetails{}}
+		for _, id := range res.MachineIDs {
+			// A listed machine is always returned, even if its details can't
+			// be fetched, so the agent doesn't create a second one.
+			machine, err := bitrise.Machines(ctx).Get(ctx, id)
+			if err != nil {
+				result.Machines = append(result.Machines, machineDetails{MachineID: id, State: machineStateUnknown, Error: err.Error()})
+			} else {
+				result.Machines = append(result.Machines, newMachineDetails(id, machine, time.Now()))
+			}
+			result.MachineIDs = append(result.MachineIDs, id)
 		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultStructuredOnly(result), nil
 	},
 }
+
+// listMachinesResult is the result of bitrise_remote_machine_list.
+type listMachinesResult struct {
+	MachineIDs []string         `json:"machine_ids" jsonschema:"description=The IDs of the running machines"`
+	Machines   []machineDetails `json:"machines" jsonschema:"description=The state and details of the running machines"`
+}
@@ -0,0 +1,232 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var ListMachineSpecs = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_list_specs",
+		mcp.WithDescription(
+			`List the stacks (macOS and Xcode versions) and machine types (CPU and RAM) remote macOS virtual machines
+can be created with, and the lifetimes they can be requested with.
+
+PURPOSE:
+Use this tool before bitrise_remote_machine_create when the task needs a specific Xcode or macOS version
+(e.g. to reproduce a bug reported on Xcode 15.4), or more CPUs or RAM than the default machine type.
+
+NOTES:
+- Pass the ID of a stack or machine type to bitrise_remote_machine_create, or the versions and the size you
+  need: the matching stack and machine type are selected from this list.
+- VMs are created with default_stack, default_machine_type and default_lifetime_minutes unless create is
+  called with other specs.
+
+RETURNS: stacks (each with id, macos_version and xcode_version), machine_types (each with id, cpu_count and
+ram_gb), default_stack, default_machine_type, default_lifetime_minutes and max_lifetime_minutes.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[machineSpecsResult](),
+	),
+	Group: bitrise.GroupLifecycle,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		specs, err := machineSpecs(ctx)
+		if err != nil {
+			return newToolResultAPIError("failed to list machine specs", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(newMachineSpecsResult(specs)), nil
+	},
+}
+
+// machineSpecsResult is the result of bitrise_remote_machine_list_specs.
+type machineSpecsResult struct {
+	Stacks                 []stackSpec       `json:"stacks" jsonschema:"description=The images machines can be created with"`
+	MachineTypes           []machineTypeSpec `json:"machine_types" jsonschema:"description=The hardware machines can be created with"`
+	DefaultStack           string            `json:"default_stack,omitempty" jsonschema:"description=The stack machines are created with by default"`
+	DefaultMachineType     string            `json:"default_machine_type,omitempty" jsonschema:"description=The machine type machines are created with by default"`
+	DefaultLifetimeMinutes int               `json:"default_lifetime_minutes,omitempty" jsonschema:"description=The lifetime machines are created with by default"`
+	MaxLifetimeMinutes     int               `json:"max_lifetime_minutes,omitempty" jsonschema:"description=The longest lifetime that can be requested. Not limited if missing"`
+}
+
+type stackSpec struct {
+	ID           string `json:"id" jsonschema:"description=The ID to create machines with"`
+	MacOSVersion string `json:"macos_version" jsonschema:"description=The macOS version of the image"`
+	XcodeVersion string `json:"xcode_version" jsonschema:"description=The Xcode version of the image"`
+}
+
+type machineTypeSpec struct {
+	ID       string `json:"id" jsonschema:"description=The ID to create machines with"`
+	CPUCount int    `json:"cpu_count" jsonschema:"description=The number of CPU cores"`
+	RAMGB    int    `json:"ram_gb" jsonschema:"description=The RAM in GB"`
+}
+
+func newMachineSpecsResult(specs *bitrise.MachineSpecs) machineSpecsResult {
+	result := machineSpecsResult{
+		Stacks:                 make([]stackSpec, 0, len(specs.Stacks)),
+		MachineTypes:           make([]machineTypeSpec, 0, len(specs.MachineTypes)),
+		DefaultStack:           specs.DefaultStack,
+		DefaultMachineType:     specs.DefaultMachineType,
+		DefaultLifetimeMinutes: specs.DefaultLifetimeMinutes,
+		MaxLifetimeMinutes:     specs.MaxLifetimeMinutes,
+	}
+	for _, st := range specs.Stacks {
+		result.Stacks = append(result.Stacks, stackSpec{ID: st.ID, MacOSVersion: st.MacOSVersion, XcodeVersion: st.XcodeVersion})
+	}
+	for _, mt := range specs.MachineTypes {
+		result.MachineTypes = append(result.MachineTypes, machineTypeSpec{ID: mt.ID, CPUCount: mt.CPUCount, RAMGB: mt.RAMGB})
+	}
+	return result
+}
+
+type machineSpecsKey struct{}
+
+// errNoMachineSpecs is returned if neither a catalog is configured, nor the
+// API provides one. It doesn't wrap bitrise.ErrNotFound, which would tell the
+// agent that the machine is gone.
+var errNoMachineSpecs = errors.New("no catalog of machine specs is available: the Bitrise API doesn't provide one, " +
+	"and MCP_MACHINE_SPECS_FILE is not configured. Create the machine without stack, version, machine type, size " +
+	"and lifetime parameters, or ask the user to configure MCP_MACHINE_SPECS_FILE")
+
+// machineSpecs returns the configured catalog of machine specs, or fetches it from the API.
+func machineSpecs(ctx context.Context) (*bitrise.MachineSpecs, error) {
+	if specs, ok := ctx.Value(machineSpecsKey{}).(*bitrise.MachineSpecs); ok {
+		return specs, nil
+	}
+	specs, err := bitrise.Machines(ctx).Specs(ctx)
+	if errors.Is(err, bitrise.ErrNotFound) {
+		return nil, errNoMachineSpecs
+	}
+	return specs, err
+}
+
+// specRequest are the specs requested from bitrise_remote_machine_create.
+// The zero values mean any.
+type specRequest struct {
+	Stack           string
+	MacOSVersion    string
+	XcodeVersion    string
+	MachineType     string
+	CPUCount        int
+	RAMGB           int
+	LifetimeMinutes int
+}
+
+// resolveSpecs validates the requested specs against the catalog. Of the
+// stacks matching the requested versions the newest is selected, and of the
+// machine types with the requested size the smallest. The API defaults are
+// kept for what isn't requested.
+func resolveSpecs(specs *bitrise.MachineSpecs, req specRequest) (bitrise.CreateMachineRequest, error) {
+	var create bitrise.CreateMachineRequest
+
+	if req.Stack != "" || req.MacOSVersion != "" || req.XcodeVersion != "" {
+		var matching []bitrise.Stack
+		for _, st := range specs.Stacks {
+			if (req.Stack == "" || st.ID == req.Stack) && matchesVersion(st.MacOSVersion, req.MacOSVersion) && matchesVersion(st.XcodeVersion, req.XcodeVersion) {
+				matching = append(matching, st)
+			}
+		}
+		if len(matching) == 0 {
+			available := make([]string, 0, len(specs.Stacks))
+			for _, st := range specs.Stacks {
+				available = append(available, fmt.Sprintf("%s (macOS %s, Xcode %s)", st.ID, st.MacOSVersion, st.XcodeVersion))
+			}
+			return create, fmt.Errorf("no stack matches %s. Available stacks: %s", describeStackRequest(req), strings.Join(available, ", "))
+		}
+		create.Stack = slices.MaxFunc(matching, func(a, b bitrise.Stack) int {
+			if c := compareVersions(a.XcodeVersion, b.XcodeVersion); c != 0 {
+				return c
+			}
+			return compareVersions(a.MacOSVersion, b.MacOSVersion)
+		}).ID
+	}
+
+	if req.MachineType != "" || req.CPUCount > 0 || req.RAMGB > 0 {
+		var matching []bitrise.MachineType
+		for _, mt := range specs.MachineTypes {
+			if (req.MachineType == "" || mt.ID == req.MachineType) && mt.CPUCount >= req.CPUCount && mt.RAMGB >= req.RAMGB {
+				matching = append(matching, mt)
+			}
+		}
+		if len(matching) == 0 {
+			available := make([]string, 0, len(specs.MachineTypes))
+			for _, mt := range specs.MachineTypes {
+				available = append(available, fmt.Sprintf("%s (%d CPUs, %d GB RAM)", mt.ID, mt.CPUCount, mt.RAMGB))
+			}
+			return create, fmt.Errorf("no machine type matches %s. Available machine types: %s", describeMachineTypeRequest(req), strings.Join(available, ", "))
+		}
+		create.MachineType = slices.MinFunc(matching, func(a, b bitrise.MachineType) int {
+			if a.CPUCount != b.CPUCount {
+				return a.CPUCount - b.CPUCount
+			}
+			return a.RAMGB - b.RAMGB
+		}).ID
+	}
+
+	if req.LifetimeMinutes > 0 {
+		if specs.MaxLifetimeMinutes > 0 && req.LifetimeMinutes > specs.MaxLifetimeMinutes {
+			return create, fmt.Errorf("lifetime_minutes must be at most %d", specs.MaxLifetimeMinutes)
+		}
+		create.LifetimeMinutes = req.LifetimeMinutes
+	}
+	return create, nil
+}
+
+func describeStackRequest(req specRequest) string {
+	var parts []string
+	if req.Stack != "" {
+		parts = append(parts, fmt.Sprintf("ID %q", req.Stack))
+	}
+	if req.MacOSVersion != "" {
+		parts = append(parts, "macOS "+req.MacOSVersion)
+	}
+	if req.XcodeVersion != "" {
+		parts = append(parts, "Xcode "+req.XcodeVersion)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeMachineTypeRequest(req specRequest) string {
+	var parts []string
+	if req.MachineType != "" {
+		parts = append(parts, fmt.Sprintf("ID %q", req.MachineType))
+	}
+	if req.CPUCount > 0 {
+		parts = append(parts, fmt.Sprintf("at least %d CPUs", req.CPUCount))
+	}
+	if req.RAMGB > 0 {
+		parts = append(parts, fmt.Sprintf("at least %d GB RAM", req.RAMGB))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// matchesVersion tells whether the version is the wanted one, or one of its
+// patch versions: 16 and 16.4 match 16.4.1, but 16.4 doesn't match 16.41.
+func matchesVersion(version, want string) bool {
+	return want == "" || version == want || strings.HasPrefix(version, want+".")
+}
+
+// compareVersions compares dotted versions numerically.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
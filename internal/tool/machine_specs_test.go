@@ -0,0 +1,123 @@
+package tool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+)
+
+func TestResolveSpecs(t *testing.T) {
+	specs := &bitrise.MachineSpecs{
+		Stacks: []bitrise.Stack{
+			{ID: "xcode-15.4", MacOSVersion: "14.5", XcodeVersion: "15.4"},
+			{ID: "xcode-16.2", MacOSVersion: "15.3", XcodeVersion: "16.2"},
+			{ID: "xcode-16.10", MacOSVersion: "15.6", XcodeVersion: "16.10"},
+			{ID: "xcode-16.4-sequoia", MacOSVersion: "15.5", XcodeVersion: "16.4"},
+			{ID: "xcode-16.4-sonoma", MacOSVersion: "14.7", XcodeVersion: "16.4"},
+		},
+		MachineTypes: []bitrise.MachineType{
+			{ID: "x-large", CPUCount: 12, RAMGB: 48},
+			{ID: "medium", CPUCount: 4, RAMGB: 12},
+			{ID: "large", CPUCount: 8, RAMGB: 24},
+			{ID: "large-ram", CPUCount: 8, RAMGB: 64},
+		},
+		MaxLifetimeMinutes: 240,
+	}
+
+	tests := []struct {
+		name    string
+		req     specRequest
+		want    bitrise.CreateMachineRequest
+		wantErr string
+	}{
+		{name: "nothing requested", req: specRequest{}},
+		{name: "stack ID", req: specRequest{Stack: "xcode-16.2"}, want: bitrise.CreateMachineRequest{Stack: "xcode-16.2"}},
+		{name: "newest Xcode of a major version", req: specRequest{XcodeVersion: "16"}, want: bitrise.CreateMachineRequest{Stack: "xcode-16.10"}},
+		{name: "newest macOS of an Xcode version", req: specRequest{XcodeVersion: "16.4"}, want: bitrise.CreateMachineRequest{Stack: "xcode-16.4-sequoia"}},
+		{name: "Xcode and macOS version", req: specRequest{XcodeVersion: "16.4", MacOSVersion: "14"}, want: bitrise.CreateMachineRequest{Stack: "xcode-16.4-sonoma"}},
+		{name: "version prefix is not a number prefix", req: specRequest{XcodeVersion: "16.1"}, wantErr: "no stack matches Xcode 16.1"},
+		{name: "stack ID with other version", req: specRequest{Stack: "xcode-16.2", MacOSVersion: "15.5"}, wantErr: `no stack matches ID "xcode-16.2", macOS 15.5`},
+		{name: "smallest machine type", req: specRequest{CPUCount: 6}, want: bitrise.CreateMachineRequest{MachineType: "large"}},
+		{name: "smallest machine type with RAM", req: specRequest{CPUCount: 6, RAMGB: 32}, want: bitrise.CreateMachineRequest{MachineType: "large-ram"}},
+		{name: "machine type ID", req: specRequest{MachineType: "x-large"}, want: bitrise.CreateMachineRequest{MachineType: "x-large"}},
+		{name: "too large machine type", req: specRequest{RAMGB: 128}, wantErr: "no machine type matches at least 128 GB RAM"},
+		{name: "lifetime", req: specRequest{LifetimeMinutes: 240}, want: bitrise.CreateMachineRequest{LifetimeMinutes: 240}},
+		{name: "lifetime over the cap", req: specRequest{LifetimeMinutes: 241}, wantErr: "lifetime_minutes must be at most 240"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSpecs(specs, tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Without a cap, any lifetime is passed on to the API.
+	got, err := resolveSpecs(&bitrise.MachineSpecs{}, specRequest{LifetimeMinutes: 1000})
+	if err != nil || got.LifetimeMinutes != 1000 {
+		t.Errorf("uncapped lifetime: got %+v, %v", got, err)
+	}
+}
+
+func TestMatchesVersion(t *testing.T) {
+	tests := []struct {
+		version, want string
+		match         bool
+	}{
+		{"16.4", "", true},
+		{"16.4", "16.4", true},
+		{"16.4", "16", true},
+		{"16.4.1", "16.4", true},
+		{"16.41", "16.4", false},
+		{"16.4", "16.4.1", false},
+		{"15.4", "16", false},
+		{"160.1", "16", false},
+	}
+	for _, tt := range tests {
+		if got := matchesVersion(tt.version, tt.want); got != tt.match {
+			t.Errorf("matchesVersion(%q, %q) = %v, want %v", tt.version, tt.want, got, tt.match)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int // sign of the result
+	}{
+		{"16.4", "16.4", 0},
+		{"16.4", "16.4.0", 0},
+		{"16.10", "16.9", 1},
+		{"16.4", "16.4.1", -1},
+		{"15.4", "16", -1},
+		{"26.0", "16.4", 1},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if sign(got) != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
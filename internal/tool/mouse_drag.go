@@ -2,8 +2,6 @@ package tool
 
 import (
 	"context"
-	"fmt"
-	"net/http"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -48,6 +46,8 @@ USAGE:
 Use this tool in combination with bitrise_remote_machine_screenshot to identify coordinates
 and verify drag results. The screen resolution is 1024x768.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to perform the drag on"),
 			mcp.Required(),
@@ -69,6 +69,7 @@ and verify drag results. The screen resolution is 1024x768.`,
 			mcp.Required(),
 		),
 	),
+	Group: bitrise.GroupGUI,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -95,22 +96,15 @@ and verify drag results. The screen resolution is 1024x768.`,
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{
-			"startX": int(startX),
-			"startY": int(startY),
-			"endX":   int(endX),
-			"endY":   int(endY),
-		}
-
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/mouse_drag", machineID),
-			Body:    body,
+		err = bitrise.Machines(ctx).MouseDrag(ctx, machineID, bitrise.MouseDragRequest{
+			StartX: int(startX),
+			StartY: int(startY),
+			EndX:   int(endX),
+			EndY:   int(endY),
 		})
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to perform mouse drag", err), nil
+			return newToolResultAPIError("failed to perform mouse drag", err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultText(""), nil
 	},
 }
@@ -0,0 +1,86 @@
+package tool
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const methodNotificationProgress = "notifications/progress"
+
+// progressReporter sends progress notifications tied to the progress token of
+// a tool call. Clients opt in to them by sending a progress token, so a
+// reporter for a call without one is disabled and does nothing.
+type progressReporter struct {
+	server *server.MCPServer
+	token  mcp.ProgressToken
+}
+
+func newProgressReporter(ctx context.Context, request mcp.CallToolRequest) *progressReporter {
+	r := &progressReporter{server: server.ServerFromContext(ctx)}
+	if meta := request.Params.Meta; meta != nil {
+		r.token = meta.ProgressToken
+	}
+	return r
+}
+
+func (r *progressReporter) enabled() bool {
+	return r.server != nil && r.token != nil
+}
+
+// report sends a progress notification. progress must increase with every
+// call; total is optional (0 if unknown).
+func (r *progressReporter) report(ctx context.Context, progress, total float64, message string) {
+	if !r.enabled() {
+		return
+	}
+	params := map[string]any{
+		"progressToken": r.token,
+		"progress":      progress,
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	if message != "" {
+		params["message"] = message
+	}
+	// Notifications are best effort, the call must not fail because of them.
+	_ = r.server.SendNotificationToClient(ctx, methodNotificationProgress, params)
+}
+
+// logToClient sends a logging notification, if the client's log level allows it.
+func logToClient(ctx context.Context, level mcp.LoggingLevel, logger string, data any) {
+	if srv := server.ServerFromContext(ctx); srv != nil {
+		_ = srv.SendLogMessageToClient(ctx, mcp.NewLoggingMessageNotification(level, logger, data))
+	}
+}
+
+// lineBuffer splits a stream of output chunks into complete lines.
+type lineBuffer struct {
+	partial string
+}
+
+// write returns the complete lines of the output written so far, without
+// their trailing newlines.
+func (b *lineBuffer) write(chunk string) []string {
+	b.partial += chunk
+	i := strings.LastIndexByte(b.partial, '\n')
+	if i < 0 {
+		return nil
+	}
+	lines := strings.Split(b.partial[:i], "\n")
+	b.partial = b.partial[i+1:]
+	return lines
+}
+
+// flush returns the last, unterminated line, if any.
+func (b *lineBuffer) flush() []string {
+	if b.partial == "" {
+		return nil
+	}
+	line := b.partial
+	b.partial = ""
+	return []string{line}
+}
@@ -0,0 +1,123 @@
+package tool
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var ReadFile = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_read_file",
+		mcp.WithDescription(
+			`Read a small text file on the remote macOS virtual machine, and return its content directly.
+
+PURPOSE:
+Use this tool to look at configuration files, logs, scripts or source files on the VM (e.g. Info.plist,
+Podfile.lock, an xcodebuild log excerpt) without a download round trip, and instead of running cat with
+bitrise_remote_machine_execute.
+
+PARAMETERS:
+- machine_id (required): The VM to read the file from.
+- path (required): The path of the file on the VM. Relative paths are relative to the home directory.
+
+LIMITS:
+- Files larger than 256 KiB are refused: use bitrise_remote_machine_download for them, or
+  bitrise_remote_machine_execute with grep, head or tail to look at a part of a log.
+- Only UTF-8 text files can be read. Use bitrise_remote_machine_download for binary files (images, archives,
+  binary plists).
+
+RETURNS: The content of the file, or error details.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to read the file from"),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("The path of the file on the VM, relative to the home directory if not absolute"),
+			mcp.Required(),
+		),
+	),
+	Group: bitrise.GroupTransfer,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filePath, err := request.RequireString("path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		content, err := readRemoteFile(ctx, machineID, filePath)
+		var fileErr *remoteFileError
+		if errors.As(err, &fileErr) {
+			return mcp.NewToolResultError(fileErr.Error()), nil
+		}
+		if err != nil {
+			return newToolResultAPIError("failed to read file", err), nil
+		}
+		if !isText(content) {
+			return mcp.NewToolResultError(fmt.Sprintf(
+				"%s is not a UTF-8 text file. Use bitrise_remote_machine_download to download it.", filePath)), nil
+		}
+		return mcp.NewToolResultText(string(content)), nil
+	},
+}
+
+// readRemoteFile returns the content of a file of at most maxInlineFileBytes
+// on the remote machine. Files the script can't read are reported with a
+// *remoteFileError, failed API calls with their error.
+func readRemoteFile(ctx context.Context, machineID, filePath string) ([]byte, error) {
+	res, err := bitrise.Machines(ctx).Run(ctx, machineID, bitrise.Command{
+		Script: readFileScript(filePath),
+		// Base64 is 4/3 of the size, with line breaks.
+		MaxOutputBytes: 2 * maxInlineFileBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch res.ExitCode {
+	case 0:
+	case fileErrorExitCode:
+		return nil, newRemoteFileError("failed to read %s: %s", filePath, strings.TrimSpace(res.Stderr))
+	case fileTooLargeExitCode:
+		return nil, newRemoteFileError(
+			"%s is %s bytes, larger than the %d bytes that can be read directly. Use bitrise_remote_machine_download to download it.",
+			filePath, strings.TrimSpace(res.Stdout), maxInlineFileBytes)
+	default:
+		return nil, newRemoteFileError("failed to read %s: exit code %d: %s", filePath, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+
+	content, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(res.Stdout), ""))
+	if err != nil {
+		return nil, newRemoteFileError("failed to decode the content of %s: %s", filePath, err)
+	}
+	return content, nil
+}
+
+// isText tells whether the content can be returned as text.
+func isText(content []byte) bool {
+	return utf8.Valid(content) && !bytes.ContainsRune(content, 0)
+}
+
+// readFileScript returns a script that prints the file base64 encoded, so
+// its bytes survive the transfer as they are.
+func readFileScript(filePath string) string {
+	return fmt.Sprintf(`f=%s
+if [ ! -e "$f" ]; then echo "no such file or directory" >&2; exit %[2]d; fi
+if [ ! -f "$f" ]; then echo "not a regular file" >&2; exit %[2]d; fi
+size=$(wc -c <"$f" | tr -d ' ')
+if [ "$size" -gt %[3]d ]; then echo "$size"; exit %[4]d; fi
+base64 <"$f"`, bitrise.ShellQuote(remoteShellPath(filePath)), fileErrorExitCode, maxInlineFileBytes, fileTooLargeExitCode)
+}
@@ -0,0 +1,112 @@
+package tool
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInlineFileBytes is the size limit of the files read and written in the
+// tool calls. Larger files are transferred with the upload and download tools.
+const maxInlineFileBytes = 256 << 10
+
+// Exit codes of the file scripts, for errors that aren't failures of the script.
+const (
+	// fileErrorExitCode is used with a message for the agent on stderr,
+	// like "no such file or directory".
+	fileErrorExitCode = 2
+	// fileTooLargeExitCode is used with the size of the file on stdout.
+	fileTooLargeExitCode = 3
+)
+
+// remoteFileError is returned for files the file scripts can't handle, like
+// missing files, with a message for the agent. Other errors are failures of
+// the API.
+type remoteFileError struct {
+	message string
+}
+
+func newRemoteFileError(format string, args ...any) *remoteFileError {
+	return &remoteFileError{message: fmt.Sprintf(format, args...)}
+}
+
+func (e *remoteFileError) Error() string {
+	return e.message
+}
+
+// remoteFileInfo describes a file, folder or symlink on the remote machine.
+type remoteFileInfo struct {
+	Name       string    `json:"name" jsonschema:"description=Name of the file"`
+	Type       string    `json:"type" jsonschema:"enum=file,enum=directory,enum=symlink,enum=other,description=Type of the file. Symlinks are not followed"`
+	Size       int64     `json:"size" jsonschema:"description=Size in bytes"`
+	Mode       string    `json:"mode" jsonschema:"description=Permission bits in octal (e.g. 0644)"`
+	ModTime    time.Time `json:"mod_time" jsonschema:"description=Last modification time"`
+	LinkTarget string    `json:"link_target,omitempty" jsonschema:"description=Target of the symlink"`
+}
+
+// remoteShellPath returns a path for the file scripts. Relative paths, which
+// are relative to the home directory, get a "./" prefix so they are never
+// taken for options.
+func remoteShellPath(p string) string {
+	if path.IsAbs(p) || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") {
+		return p
+	}
+	return "./" + p
+}
+
+// statScript defines the shell function entry, which prints a line with the
+// type, size, permissions, modification time and symlink target of the file
+// in $1 followed by the name in $2, separated by tabs. macOS has BSD stat,
+// while other systems, like the fake API's host, may have GNU stat.
+const statScript = "if stat -c %s / >/dev/null 2>&1; then\n" +
+	"  st() { stat -c '%F\t%s\t%a\t%Y' \"$1\"; }\n" +
+	"else\n" +
+	"  st() { stat -f '%HT\t%z\t%Lp\t%m' \"$1\"; }\n" +
+	"fi\n" +
+	"entry() { printf '%s\\t%s\\t%s\\n' \"$(st \"$1\")\" \"$(if [ -L \"$1\" ]; then readlink \"$1\"; fi)\" \"$2\"; }\n"
+
+// parseStatEntry parses a line printed by the entry function of statScript.
+func parseStatEntry(line string) (remoteFileInfo, error) {
+	fields := strings.SplitN(line, "\t", 6)
+	if len(fields) != 6 {
+		return remoteFileInfo{}, fmt.Errorf("unexpected stat output %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return remoteFileInfo{}, fmt.Errorf("unexpected size in stat output %q", line)
+	}
+	mode, err := strconv.ParseUint(fields[2], 8, 32)
+	if err != nil {
+		return remoteFileInfo{}, fmt.Errorf("unexpected mode in stat output %q", line)
+	}
+	mtime, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return remoteFileInfo{}, fmt.Errorf("unexpected modification time in stat output %q", line)
+	}
+	return remoteFileInfo{
+		Name:       fields[5],
+		Type:       fileType(fields[0]),
+		Size:       size,
+		Mode:       fmt.Sprintf("%04o", mode),
+		ModTime:    time.Unix(mtime, 0).UTC(),
+		LinkTarget: fields[4],
+	}, nil
+}
+
+// fileType maps the type names of BSD stat ("Regular File") and GNU stat
+// ("regular empty file") to the types of remoteFileInfo.
+func fileType(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "regular"):
+		return "file"
+	case name == "directory":
+		return "directory"
+	case name == "symbolic link":
+		return "symlink"
+	default:
+		return "other"
+	}
+}
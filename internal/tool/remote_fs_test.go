@@ -0,0 +1,98 @@
+package tool
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/fakeapi"
+)
+
+func TestRemoteFiles(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+
+	plist := "<?xml version=\"1.0\"?>\n<plist><dict><key>CFBundleName</key><string>Café</string></dict></plist>\n"
+	h.mustCallTool("bitrise_remote_machine_write_file", map[string]any{"machine_id": id, "path": "MyApp/Info.plist", "content": plist})
+	h.mustCallTool("bitrise_remote_machine_write_file", map[string]any{"machine_id": id, "path": "MyApp/build.sh", "content": "#!/bin/sh\n", "mode": "0755"})
+	assertFile(t, m.Path("MyApp/Info.plist"), plist)
+
+	res := h.mustCallTool("bitrise_remote_machine_read_file", map[string]any{"machine_id": id, "path": "/Users/vagrant/MyApp/Info.plist"})
+	if got := resultText(res); got != plist {
+		t.Errorf("read %q, want %q", got, plist)
+	}
+
+	writeFiles(t, m.Path("MyApp"), map[string]string{"icon.png": "\x89PNG\x00\x01", "large.log": strings.Repeat("x", maxInlineFileBytes+1)})
+	for path, want := range map[string]string{
+		"MyApp/icon.png":  "not a UTF-8 text file",
+		"MyApp/large.log": "use bitrise_remote_machine_download",
+		"MyApp/missing":   "no such file or directory",
+		"MyApp":           "not a regular file",
+	} {
+		res := h.callTool("bitrise_remote_machine_read_file", map[string]any{"machine_id": id, "path": path})
+		if !res.IsError || !strings.Contains(strings.ToLower(resultText(res)), strings.ToLower(want)) {
+			t.Errorf("read %s: got %q, want %q", path, resultText(res), want)
+		}
+	}
+	res = h.callTool("bitrise_remote_machine_write_file", map[string]any{"machine_id": id, "path": "big.txt", "content": strings.Repeat("x", maxInlineFileBytes+1)})
+	if !res.IsError || !strings.Contains(resultText(res), "bitrise_remote_machine_upload") {
+		t.Errorf("write too large: got %q", resultText(res))
+	}
+	// Content up to the limit is written, larger content through an upload.
+	big := strings.Repeat("it's a 'quoted' line\n", maxInlineFileBytes/21)
+	big += strings.Repeat("x", maxInlineFileBytes-len(big))
+	h.mustCallTool("bitrise_remote_machine_write_file", map[string]any{"machine_id": id, "path": "big.txt", "content": big, "mode": "0600"})
+	if got := resultText(h.mustCallTool("bitrise_remote_machine_read_file", map[string]any{"machine_id": id, "path": "big.txt"})); got != big {
+		t.Errorf("read back %d bytes of the %d written", len(got), len(big))
+	}
+	if info, err := os.Stat(m.Path("big.txt")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("got file info %v, %v, want mode 0600", info, err)
+	}
+	if staged, _ := filepath.Glob(m.Path(fakeapi.HomeDir + "/.bitrise-mcp-write-*")); len(staged) > 0 {
+		t.Errorf("the staging folder is left on the machine: %v", staged)
+	}
+
+	if err := os.Symlink("Info.plist", m.Path("MyApp/.current")); err != nil {
+		t.Fatal(err)
+	}
+	var stat statResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_stat", map[string]any{"machine_id": id, "path": "MyApp/build.sh"}), &stat)
+	if !stat.Exists || stat.Info.Name != "build.sh" || stat.Info.Type != "file" || stat.Info.Size != 10 || stat.Info.Mode != "0755" ||
+		time.Since(stat.Info.ModTime) > time.Minute {
+		t.Errorf("got stat %+v %+v", stat, stat.Info)
+	}
+	stat = statResult{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_stat", map[string]any{"machine_id": id, "path": "MyApp/missing"}), &stat)
+	if stat.Exists || stat.Info != nil {
+		t.Errorf("got stat %+v for a missing file", stat)
+	}
+
+	var list listDirResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list_dir", map[string]any{"machine_id": id, "path": "MyApp"}), &list)
+	var got []string
+	for _, e := range list.Entries {
+		got = append(got, e.Name+":"+e.Type+":"+e.LinkTarget)
+	}
+	want := []string{".current:symlink:Info.plist", "Info.plist:file:", "build.sh:file:", "icon.png:file:", "large.log:file:"}
+	if !slices.Equal(got, want) || list.Truncated {
+		t.Errorf("listed %q (truncated %v), want %q", got, list.Truncated, want)
+	}
+	list = listDirResult{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_list_dir", map[string]any{"machine_id": id, "path": "MyApp", "max_entries": 2}), &list)
+	got = nil
+	for _, e := range list.Entries {
+		got = append(got, e.Name)
+	}
+	// A truncated list is the start of the sorted one, hidden files included.
+	if want := []string{".current", "Info.plist"}; !slices.Equal(got, want) || !list.Truncated {
+		t.Errorf("listed %q (truncated %v), want %q truncated", got, list.Truncated, want)
+	}
+	res = h.callTool("bitrise_remote_machine_list_dir", map[string]any{"machine_id": id, "path": "MyApp/Info.plist"})
+	if !res.IsError || !strings.Contains(resultText(res), "not a directory") {
+		t.Errorf("list a file: got %q", resultText(res))
+	}
+}
@@ -0,0 +1,127 @@
+package tool
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// remoteFileScheme is the URI scheme of the files on remote machines.
+const remoteFileScheme = "remote-machine"
+
+// RemoteFiles exposes the files and folders of the remote machines as MCP
+// resources. Files are returned as text, or as a blob if they aren't UTF-8
+// text, and folders as a JSON listing of their entries with their URIs.
+var RemoteFiles = server.ServerResourceTemplate{
+	Template: mcp.NewResourceTemplate(
+		remoteFileScheme+"://{machine_id}/{+path}",
+		"Remote machine file",
+		mcp.WithTemplateDescription(
+			`A file or folder on a remote macOS virtual machine, by its absolute path (e.g.
+remote-machine://<machine_id>/Users/vagrant/build/xcodebuild.log). Files up to 256 KiB are returned as text, or
+as a base64 blob if they aren't UTF-8 text. Folders are returned as a JSON listing of their entries, each with
+its URI. Subscribe to a file to be notified when it changes, e.g. to follow a build log. Use
+bitrise_remote_machine_download for larger files.`,
+		),
+	),
+	Handler: readRemoteFileResource,
+}
+
+// remoteFileURI returns the URI of a path on the remote machine.
+func remoteFileURI(machineID, filePath string) string {
+	return (&url.URL{Scheme: remoteFileScheme, Host: machineID, Path: path.Join("/", filePath)}).String()
+}
+
+// parseRemoteFileURI returns the machine ID and the absolute path of a
+// remote file URI.
+func parseRemoteFileURI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid resource URI %q: %w", uri, err)
+	}
+	if u.Scheme != remoteFileScheme || u.Host == "" {
+		return "", "", fmt.Errorf("invalid resource URI %q: expected %s://<machine_id>/<path>", uri, remoteFileScheme)
+	}
+	return u.Host, path.Join("/", u.Path), nil
+}
+
+// remoteFolderListing is the content of a folder resource.
+type remoteFolderListing struct {
+	Path      string             `json:"path"`
+	Entries   []remoteFolderItem `json:"entries"`
+	Truncated bool               `json:"truncated"`
+}
+
+type remoteFolderItem struct {
+	URI string `json:"uri"`
+	remoteFileInfo
+}
+
+func readRemoteFileResource(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	uri := request.Params.URI
+	machineID, filePath, err := parseRemoteFileURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := statRemoteFile(ctx, machineID, filePath)
+	if err != nil {
+		return nil, resourceError(uri, err)
+	}
+	if info == nil {
+		return nil, fmt.Errorf("failed to read %s: no such file or directory", uri)
+	}
+
+	if info.Type == "directory" {
+		list, err := listRemoteDir(ctx, machineID, filePath, maxListEntries)
+		if err != nil {
+			return nil, resourceError(uri, err)
+		}
+		listing := remoteFolderListing{Path: filePath, Entries: make([]remoteFolderItem, 0, len(list.Entries)), Truncated: list.Truncated}
+		for _, entry := range list.Entries {
+			listing.Entries = append(listing.Entries, remoteFolderItem{
+				URI:            remoteFileURI(machineID, path.Join(filePath, entry.Name)),
+				remoteFileInfo: entry,
+			})
+		}
+		content, err := json.Marshal(listing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the listing of %s: %w", uri, err)
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: "application/json", Text: string(content)}}, nil
+	}
+
+	content, err := readRemoteFile(ctx, machineID, filePath)
+	if err != nil {
+		return nil, resourceError(uri, err)
+	}
+	mimeType := mime.TypeByExtension(path.Ext(filePath))
+	if isText(content) {
+		if mimeType == "" {
+			mimeType = "text/plain"
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: mimeType, Text: string(content)}}, nil
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return []mcp.ResourceContents{mcp.BlobResourceContents{URI: uri, MIMEType: mimeType, Blob: base64.StdEncoding.EncodeToString(content)}}, nil
+}
+
+// resourceError returns the error of a resource read. Errors of the file
+// scripts already describe the file.
+func resourceError(uri string, err error) error {
+	var fileErr *remoteFileError
+	if errors.As(err, &fileErr) {
+		return fileErr
+	}
+	return fmt.Errorf("failed to read %s: %w", uri, err)
+}
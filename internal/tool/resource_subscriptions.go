@@ -0,0 +1,185 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// The resource subscription methods, which mcp-go doesn't implement.
+const (
+	methodResourcesSubscribe   = "resources/subscribe"
+	methodResourcesUnsubscribe = "resources/unsubscribe"
+)
+
+// DefaultResourcePollInterval is how often subscribed resources are checked for changes.
+const DefaultResourcePollInterval = 5 * time.Second
+
+// resourceSubscriptions polls the resources sessions subscribed to, and
+// notifies the sessions when they change.
+type resourceSubscriptions struct {
+	server   *server.MCPServer
+	interval time.Duration
+
+	mu sync.Mutex
+	// sessions are the running sessions, subscriptions of other sessions are refused.
+	sessions map[string]bool
+	// watches maps session IDs to the cancel functions of their subscriptions, by URI.
+	watches map[string]map[string]context.CancelFunc
+}
+
+// IsSubscriptionRequest tells whether the JSON-RPC message is a resource
+// subscription request, which has to be passed to HandleSubscription.
+func IsSubscriptionRequest(message json.RawMessage) bool {
+	var req struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(message, &req); err != nil {
+		return false
+	}
+	return req.Method == methodResourcesSubscribe || req.Method == methodResourcesUnsubscribe
+}
+
+// HandleSubscription handles a resources/subscribe or resources/unsubscribe
+// request of the session, and returns the response. The transports pass these
+// requests to the belt, as mcp-go doesn't handle them. The context must carry
+// the API client and the PAT of the session: they are used to poll the
+// subscribed file until the session unsubscribes or ends, or the machine is
+// deleted.
+func (b *Belt) HandleSubscription(ctx context.Context, sessionID string, message json.RawMessage) mcp.JSONRPCMessage {
+	var req struct {
+		ID     mcp.RequestId `json:"id"`
+		Method string        `json:"method"`
+		Params struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(message, &req); err != nil {
+		return mcp.NewJSONRPCError(req.ID, mcp.PARSE_ERROR, fmt.Sprintf("invalid request: %v", err), nil)
+	}
+
+	switch {
+	case b.subscriptions.server == nil || !b.groupEnabled(ctx, bitrise.GroupTransfer):
+		return mcp.NewJSONRPCError(req.ID, mcp.METHOD_NOT_FOUND, "resources are not available for this session", nil)
+	case sessionID == "":
+		return mcp.NewJSONRPCError(req.ID, mcp.INVALID_REQUEST, "resource subscriptions require a session", nil)
+	case req.Method == methodResourcesUnsubscribe:
+		b.subscriptions.unsubscribe(sessionID, req.Params.URI)
+		return mcp.NewJSONRPCResultResponse(req.ID, mcp.EmptyResult{})
+	case req.Method != methodResourcesSubscribe:
+		return mcp.NewJSONRPCError(req.ID, mcp.METHOD_NOT_FOUND, fmt.Sprintf("unexpected method %q", req.Method), nil)
+	}
+
+	machineID, filePath, err := parseRemoteFileURI(req.Params.URI)
+	if err != nil {
+		return mcp.NewJSONRPCError(req.ID, mcp.INVALID_PARAMS, err.Error(), nil)
+	}
+	// The first check validates the URI and the token, and is the state
+	// changes are detected against.
+	info, err := statRemoteFile(ctx, machineID, filePath)
+	if err != nil {
+		return mcp.NewJSONRPCError(req.ID, mcp.INVALID_PARAMS, resourceError(req.Params.URI, err).Error(), nil)
+	}
+	if !b.subscriptions.subscribe(ctx, sessionID, req.Params.URI, info) {
+		return mcp.NewJSONRPCError(req.ID, mcp.INVALID_REQUEST, fmt.Sprintf("unknown session %q", sessionID), nil)
+	}
+	return mcp.NewJSONRPCResultResponse(req.ID, mcp.EmptyResult{})
+}
+
+// subscribe starts watching the URI for the session. It returns false if the
+// session is not running.
+func (s *resourceSubscriptions) subscribe(ctx context.Context, sessionID, uri string, info *remoteFileInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sessions[sessionID] {
+		return false
+	}
+	// The subscription outlives the request.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	if s.watches[sessionID] == nil {
+		s.watches[sessionID] = make(map[string]context.CancelFunc)
+	}
+	if previous, ok := s.watches[sessionID][uri]; ok {
+		previous()
+	}
+	s.watches[sessionID][uri] = cancel
+	go s.watch(ctx, sessionID, uri, info)
+	return true
+}
+
+func (s *resourceSubscriptions) sessionStarted(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = true
+}
+
+func (s *resourceSubscriptions) sessionEnded(sessionID string) {
+	s.unsubscribe(sessionID, "")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// unsubscribe cancels the subscription of the session to the URI, or all its
+// subscriptions if uri is empty.
+func (s *resourceSubscriptions) unsubscribe(sessionID, uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for watchedURI, cancel := range s.watches[sessionID] {
+		if uri == "" || watchedURI == uri {
+			cancel()
+			delete(s.watches[sessionID], watchedURI)
+		}
+	}
+	if len(s.watches[sessionID]) == 0 {
+		delete(s.watches, sessionID)
+	}
+}
+
+// watch checks the file at every interval, and notifies the session when its
+// type, size, mode or modification time changed, or when it was created or
+// removed.
+func (s *resourceSubscriptions) watch(ctx context.Context, sessionID, uri string, last *remoteFileInfo) {
+	machineID, filePath, _ := parseRemoteFileURI(uri)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := statRemoteFile(ctx, machineID, filePath)
+		machineGone := errors.Is(err, bitrise.ErrNotFound)
+		if err != nil && !machineGone {
+			// Transient errors are retried at the next interval.
+			continue
+		}
+		if !machineGone && sameFileInfo(info, last) {
+			continue
+		}
+		last = info
+
+		// A gone machine is reported once, the next read tells the client why.
+		err = s.server.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+		if machineGone || errors.Is(err, server.ErrSessionNotFound) {
+			s.unsubscribe(sessionID, uri)
+			return
+		}
+	}
+}
+
+func sameFileInfo(a, b *remoteFileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Type == b.Type && a.Size == b.Size && a.Mode == b.Mode && a.ModTime.Equal(b.ModTime) && a.LinkTarget == b.LinkTarget
+}
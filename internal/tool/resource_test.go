@@ -0,0 +1,107 @@
+package tool
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestRemoteFileResources(t *testing.T) {
+	h := newHarness(t, withBeltOptions(WithResourcePollInterval(10*time.Millisecond)))
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+	writeFiles(t, m.Path("MyApp"), map[string]string{"build.log": "Compiling\n", "icon.png": "\x89PNG\x00\x01"})
+	uri := func(rel string) string { return "remote-machine://" + id + "/Users/vagrant/" + rel }
+
+	var templates mcp.ListResourceTemplatesResult
+	h.request(mcp.MethodResourcesTemplatesList, map[string]any{}, &templates)
+	if len(templates.ResourceTemplates) != 1 || templates.ResourceTemplates[0].URITemplate.Raw() != "remote-machine://{machine_id}/{+path}" {
+		t.Fatalf("got resource templates %+v", templates.ResourceTemplates)
+	}
+
+	contents, err := h.readResource(uri("MyApp/build.log"))
+	if text, ok := firstContent(contents).(mcp.TextResourceContents); err != nil || !ok || text.Text != "Compiling\n" || text.URI != uri("MyApp/build.log") {
+		t.Errorf("read log: got %+v, %v", contents, err)
+	}
+	contents, err = h.readResource(uri("MyApp/icon.png"))
+	if blob, ok := firstContent(contents).(mcp.BlobResourceContents); err != nil || !ok || blob.MIMEType != "image/png" ||
+		blob.Blob != base64.StdEncoding.EncodeToString([]byte("\x89PNG\x00\x01")) {
+		t.Errorf("read icon: got %+v, %v", contents, err)
+	}
+
+	contents, err = h.readResource(uri("MyApp"))
+	text, ok := firstContent(contents).(mcp.TextResourceContents)
+	if err != nil || !ok || text.MIMEType != "application/json" {
+		t.Fatalf("read folder: got %+v, %v", contents, err)
+	}
+	var listing remoteFolderListing
+	if err := json.Unmarshal([]byte(text.Text), &listing); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range listing.Entries {
+		got = append(got, e.Name+":"+e.Type+":"+e.URI)
+	}
+	want := []string{"build.log:file:" + uri("MyApp/build.log"), "icon.png:file:" + uri("MyApp/icon.png")}
+	if listing.Path != "/Users/vagrant/MyApp" || !slices.Equal(got, want) {
+		t.Errorf("listed %s %q, want %q", listing.Path, got, want)
+	}
+
+	if _, err := h.readResource(uri("MyApp/missing")); err == nil || !strings.Contains(err.Error(), "no such file or directory") {
+		t.Errorf("read missing file: got %v", err)
+	}
+
+	waitForUpdate := func(uri string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			for _, n := range h.notifications() {
+				if n.Method == mcp.MethodNotificationResourceUpdated && n.Params.AdditionalFields["uri"] == uri {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("no update notification for %s", uri)
+	}
+	h.request("resources/subscribe", map[string]any{"uri": uri("MyApp/build.log")}, nil)
+	writeFiles(t, m.Path("MyApp"), map[string]string{"build.log": "Compiling\nLinking\n"})
+	waitForUpdate(uri("MyApp/build.log"))
+	h.request("resources/unsubscribe", map[string]any{"uri": uri("MyApp/build.log")}, nil)
+	h.belt.subscriptions.mu.Lock()
+	if watches := h.belt.subscriptions.watches; len(watches) != 0 {
+		t.Errorf("subscriptions left after unsubscribing: %v", watches)
+	}
+	h.belt.subscriptions.mu.Unlock()
+
+	// A file that doesn't exist yet can be watched too, e.g. the log of a build that didn't start yet.
+	h.request("resources/subscribe", map[string]any{"uri": uri("MyApp/test.log")}, nil)
+	writeFiles(t, m.Path("MyApp"), map[string]string{"test.log": "Testing\n"})
+	waitForUpdate(uri("MyApp/test.log"))
+	h.mustCallTool("bitrise_remote_machine_delete", map[string]any{"machine_id": id})
+	waitForUpdate(uri("MyApp/test.log"))
+	time.Sleep(50 * time.Millisecond)
+	h.belt.subscriptions.mu.Lock()
+	if watches := h.belt.subscriptions.watches; len(watches) != 0 {
+		t.Errorf("subscriptions left after the machine was deleted: %v", watches)
+	}
+	h.belt.subscriptions.mu.Unlock()
+
+	if _, err := h.send("resources/subscribe", map[string]any{"uri": "https://example.com/file"}); err == nil {
+		t.Error("subscribed to a URI of another scheme")
+	}
+
+	h = newHarness(t, withSessionContext(func(ctx context.Context) context.Context {
+		return bitrise.ContextWithEnabledGroups(ctx, []string{bitrise.GroupExec})
+	}))
+	if _, err := h.readResource(uri("MyApp/build.log")); err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("read without the transfer group: got %v", err)
+	}
+}
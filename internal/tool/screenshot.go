@@ -3,7 +3,6 @@ package tool
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,10 +14,6 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
-type screenshotResponse struct {
-	SignedURL string `json:"signedUrl"`
-}
-
 var Screenshot = bitrise.Tool{
 	Definition: mcp.NewTool("bitrise_remote_machine_screenshot",
 		mcp.WithDescription(
@@ -65,32 +60,23 @@ WORKFLOW EXAMPLE:
 3. Use bitrise_remote_machine_click or bitrise_remote_machine_type to interact
 4. Take another screenshot to verify the result`,
 		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to take a screenshot of"),
 			mcp.Required(),
 		),
 	),
+	Group: bitrise.GroupGUI,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{}
-
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/screenshot", machineID),
-			Body:    body,
-		})
+		screenshotResp, err := bitrise.Machines(ctx).Screenshot(ctx, machineID)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to take screenshot", err), nil
-		}
-
-		var screenshotResp screenshotResponse
-		if err := json.Unmarshal([]byte(res), &screenshotResp); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to parse screenshot response: %v", err)), nil
+			return newToolResultAPIError("failed to take screenshot", err), nil
 		}
 
 		// Download the image from signed URL
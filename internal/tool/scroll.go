@@ -2,8 +2,6 @@ package tool
 
 import (
 	"context"
-	"fmt"
-	"net/http"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -41,6 +39,8 @@ USAGE:
 Specify the scroll direction and amount. Use "up" to scroll up (content moves down),
 and "down" to scroll down (content moves up). The screen resolution is 1024x768.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to perform the scroll on"),
 			mcp.Required(),
@@ -54,6 +54,7 @@ and "down" to scroll down (content moves up). The screen resolution is 1024x768.
 			mcp.Required(),
 		),
 	),
+	Group: bitrise.GroupGUI,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -70,20 +71,13 @@ and "down" to scroll down (content moves up). The screen resolution is 1024x768.
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{
-			"direction": direction,
-			"amount":    int(amount),
-		}
-
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/scroll", machineID),
-			Body:    body,
+		err = bitrise.Machines(ctx).Scroll(ctx, machineID, bitrise.ScrollRequest{
+			Direction: direction,
+			Amount:    int(amount),
 		})
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to perform scroll", err), nil
+			return newToolResultAPIError("failed to perform scroll", err), nil
 		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultText(""), nil
 	},
 }
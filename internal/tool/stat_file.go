@@ -0,0 +1,107 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var StatFile = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_stat",
+		mcp.WithDescription(
+			`Check whether a file or folder exists on the remote macOS virtual machine, and get its type, size, mode
+and modification time.
+
+PURPOSE:
+Use this tool to check that a build produced its artifact (e.g. the .ipa or the test results bundle) and how
+large it is before downloading it, or that an uploaded file arrived, without running ls or stat with
+bitrise_remote_machine_execute.
+
+PARAMETERS:
+- machine_id (required): The VM to check the path on.
+- path (required): The path on the VM. Relative paths are relative to the home directory.
+
+NOTES:
+- Symlinks are not followed: a symlink is reported with its type "symlink" and its link_target.
+- The size of a folder is the size of the folder entry, not of its content. Use bitrise_remote_machine_execute
+  with "du -sh" for the size of the content.
+
+RETURNS: path, exists, and if the path exists, info with its name, type (file, directory, symlink or other),
+size, mode, mod_time and link_target.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[statResult](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to check the path on"),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("The path on the VM, relative to the home directory if not absolute"),
+			mcp.Required(),
+		),
+	),
+	Group: bitrise.GroupTransfer,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filePath, err := request.RequireString("path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		info, err := statRemoteFile(ctx, machineID, filePath)
+		var fileErr *remoteFileError
+		if errors.As(err, &fileErr) {
+			return mcp.NewToolResultError(fileErr.Error()), nil
+		}
+		if err != nil {
+			return newToolResultAPIError("failed to stat file", err), nil
+		}
+		result := statResult{Path: filePath, Exists: info != nil, Info: info}
+		return mcp.NewToolResultStructuredOnly(result), nil
+	},
+}
+
+// statResult is the result of bitrise_remote_machine_stat.
+type statResult struct {
+	Path   string          `json:"path" jsonschema:"description=The path as it was requested"`
+	Exists bool            `json:"exists" jsonschema:"description=True if the path exists"`
+	Info   *remoteFileInfo `json:"info,omitempty" jsonschema:"description=The file info\\, if the path exists"`
+}
+
+// statRemoteFile returns the info of a path on the remote machine, or nil if
+// it doesn't exist.
+func statRemoteFile(ctx context.Context, machineID, filePath string) (*remoteFileInfo, error) {
+	res, err := bitrise.Machines(ctx).Run(ctx, machineID, bitrise.Command{Script: statFileScript(filePath)})
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, newRemoteFileError("failed to stat %s: exit code %d: %s", filePath, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+	line := strings.TrimSuffix(res.Stdout, "\n")
+	if line == "missing" {
+		return nil, nil
+	}
+	info, err := parseStatEntry(line)
+	if err != nil {
+		return nil, newRemoteFileError("failed to stat %s: %s", filePath, err)
+	}
+	return &info, nil
+}
+
+func statFileScript(filePath string) string {
+	return fmt.Sprintf(`f=%s
+if [ ! -e "$f" ] && [ ! -L "$f" ]; then echo missing; exit 0; fi
+%sentry "$f" %s`, bitrise.ShellQuote(remoteShellPath(filePath)), statScript, bitrise.ShellQuote(path.Base(filePath)))
+}
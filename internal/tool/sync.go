@@ -0,0 +1,207 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var Sync = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_sync",
+		mcp.WithDescription(
+			`Sync a local folder to a folder on the remote macOS virtual machine, uploading only what changed.
+
+PURPOSE:
+Use this tool for the edit locally → sync → build remotely loop. The first sync uploads the whole folder,
+later syncs to the same machine and destination only upload the added and changed files, and delete the
+files that were removed locally (like rsync --delete). A one-line edit takes seconds instead of re-uploading
+the whole project.
+
+HOW IT WORKS:
+- A manifest with the content hash of every synced file is kept locally for each machine, source_path
+  and destination_folder.
+- Each sync compares the folder with the manifest, deletes the removed files on the VM, then uploads
+  the added and changed files as a tar.gz archive.
+- Files created on the VM (build outputs, DerivedData, installed Pods) are never deleted, as they are
+  not in the manifest.
+
+PARAMETERS:
+- machine_id (required): The VM to sync the folder to.
+- source_path (required): The absolute path to the local folder to sync.
+- destination_folder (required): The absolute path on the VM of the folder to mirror the contents of
+  source_path in (e.g., "/Users/vagrant/MyApp"). It is created if it doesn't exist.
+- include (optional): Globs of the files to sync, relative to source_path. All files are synced if empty.
+- exclude (optional): Globs of the files and folders not to sync, relative to source_path
+  (e.g., ["DerivedData", "build/**", "Pods", "node_modules"]).
+- respect_gitignore (optional): If true, the files ignored by the .gitignore files at every level of the
+  folder are not synced, and neither is the .git folder. Defaults to false.
+- full (optional): If true, the whole folder is uploaded, not only the files that changed since the last
+  sync. The files removed locally are still deleted. Use it if files in destination_folder were modified
+  on the VM and should be reset. Defaults to false.
+
+IMPORTANT NOTES:
+- Globs use .gitignore syntax, and .remotemachineignore files are honored, like with bitrise_remote_machine_upload.
+- Files that become excluded are deleted from the VM on the next sync.
+- If destination_folder was deleted on the VM, the whole folder is uploaded again.
+- Only use this tool for folders. Use bitrise_remote_machine_upload for single files.
+
+EXAMPLE USAGE:
+1. bitrise_remote_machine_sync(machine_id="abc123", source_path="/local/MyApp", destination_folder="/Users/vagrant/MyApp", respect_gitignore=true)
+2. bitrise_remote_machine_execute(machine_id="abc123", bash_command="xcodebuild test ...", cwd="/Users/vagrant/MyApp")
+3. Edit files locally, then call the same sync again: only the edited files are uploaded.
+
+RETURNS: The number of added, changed, deleted and unchanged files, the uploaded bytes, and the size and
+compression ratio of the archive. If the request has a progress token, the upload reports progress like
+bitrise_remote_machine_upload.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to sync to"),
+			mcp.Required(),
+		),
+		mcp.WithString("source_path",
+			mcp.Description("The absolute path to the local folder to sync"),
+			mcp.Required(),
+		),
+		mcp.WithString("destination_folder",
+			mcp.Description("The absolute path on the VM of the folder to mirror the contents of source_path in"),
+			mcp.Required(),
+		),
+		mcp.WithArray("include",
+			mcp.Description("Globs of the files to sync, relative to source_path. All files are synced if empty"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Globs of the files and folders not to sync, relative to source_path"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithBoolean("respect_gitignore",
+			mcp.Description("If true, the files ignored by the .gitignore files of the folder and the .git folder are not synced"),
+		),
+		mcp.WithBoolean("full",
+			mcp.Description("If true, the whole folder is uploaded, not only the files that changed since the previous sync"),
+		),
+	),
+	Group: bitrise.GroupTransfer,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		sourcePath, err := request.RequireString("source_path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		destinationFolder, err := request.RequireString("destination_folder")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filter, err := newUploadFilter(
+			request.GetStringSlice("include", nil),
+			request.GetStringSlice("exclude", nil),
+			request.GetBool("respect_gitignore", false),
+		)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		sourcePath, err = filepath.Abs(sourcePath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to access source path: %v", err)), nil
+		}
+		sourceInfo, err := os.Stat(sourcePath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to access source path: %v", err)), nil
+		}
+		if !sourceInfo.IsDir() {
+			return mcp.NewToolResultError("source_path must be a folder, use bitrise_remote_machine_upload to upload a single file"), nil
+		}
+
+		// Step 1: Compare the folder with the manifest of the previous sync
+		manifests := transferSettingsFromContext(ctx).manifests
+		manifest, err := manifests.load(ctx, machineID, sourcePath, destinationFolder)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to load sync manifest: %v", err)), nil
+		}
+		var previous map[string]syncEntry
+		if manifest != nil {
+			previous = manifest.Entries
+		}
+		current, stats, err := scanSyncSource(sourcePath, filter, previous)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to scan source path: %v", err)), nil
+		}
+		// A full sync uploads every file, but still deletes the removed ones.
+		full := request.GetBool("full", false)
+		plan := planSync(previous, current, full)
+
+		// Step 2: Delete the removed files, and check that the previous sync is still there
+		machines := bitrise.Machines(ctx)
+		if previous != nil {
+			for _, script := range syncRemoveScripts(destinationFolder, plan.remove) {
+				res, err := machines.Run(ctx, machineID, bitrise.Command{Script: script})
+				if err != nil {
+					return newToolResultAPIError("failed to delete removed files", err), nil
+				}
+				if res.ExitCode != 0 {
+					return mcp.NewToolResultError(fmt.Sprintf("failed to delete removed files: exit code %d: %s", res.ExitCode, res.Stderr)), nil
+				}
+				if strings.TrimSpace(res.Stdout) == "missing" {
+					plan = planSync(nil, current, full)
+					break
+				}
+			}
+		}
+
+		// Step 3: Upload the added and changed files
+		var uploadedBytes int64
+		var summary string
+		if len(plan.upload) > 0 {
+			for _, rel := range plan.upload {
+				uploadedBytes += current[rel].Size
+			}
+			progress := newTransferProgress(ctx, newProgressReporter(ctx, request), true, uploadedBytes)
+			upload, err := uploadArchive(ctx, machineID, destinationFolder, transferSettingsFromContext(ctx).partBytes, progress, func(w io.Writer) error {
+				return writeSyncArchive(w, sourcePath, plan.upload, progress)
+			})
+			if err != nil {
+				return newToolResultAPIError("failed to upload", err), nil
+			}
+			summary = upload.summary(uploadedBytes)
+		}
+
+		// Step 4: Save the manifest for the next sync
+		msg := fmt.Sprintf("Successfully synced %s to %s on machine %s: %d added, %d changed, %d deleted, %d unchanged (%d bytes uploaded)",
+			sourcePath, destinationFolder, machineID, plan.added, plan.changed, plan.removed, plan.unchanged, uploadedBytes)
+		if stats.skippedFiles > 0 {
+			msg += fmt.Sprintf("\nSkipped %d files (%d bytes) matching the exclude globs or ignore files, or not matching the include globs.",
+				stats.skippedFiles, stats.skippedBytes)
+		}
+		if summary != "" {
+			msg += "\n" + summary
+		}
+		err = manifests.save(ctx, &syncManifest{
+			MachineID:   machineID,
+			SourcePath:  sourcePath,
+			Destination: destinationFolder,
+			SyncedAt:    time.Now().UTC(),
+			Entries:     current,
+		})
+		if err != nil {
+			msg += fmt.Sprintf("\n(Note: Failed to save the sync manifest, the next sync will upload everything: %v)", err)
+		}
+		return mcp.NewToolResultText(msg), nil
+	},
+}
@@ -0,0 +1,297 @@
+package tool
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+)
+
+// syncManifestRetention is how long the manifest of a synced folder is kept
+// after its last sync. Machines live much shorter than this.
+const syncManifestRetention = 7 * 24 * time.Hour
+
+// syncEntry is the state of a synced file, folder or symlink.
+type syncEntry struct {
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mod_time,omitzero"`
+	SHA256  string      `json:"sha256,omitempty"`
+	Link    string      `json:"link,omitempty"`
+}
+
+// sameContent reports whether two entries need no upload to turn one into the other.
+func (e syncEntry) sameContent(other syncEntry) bool {
+	return e.Mode == other.Mode && e.SHA256 == other.SHA256 && e.Link == other.Link
+}
+
+// syncManifest is the state of a local folder after it was synced to a
+// folder on a machine, keyed by slash separated paths relative to the folder.
+type syncManifest struct {
+	MachineID   string               `json:"machine_id"`
+	SourcePath  string               `json:"source_path"`
+	Destination string               `json:"destination"`
+	Owner       string               `json:"owner"`
+	SyncedAt    time.Time            `json:"synced_at"`
+	Entries     map[string]syncEntry `json:"entries"`
+}
+
+// syncManifestStore keeps the manifests of synced folders in a local directory.
+type syncManifestStore struct {
+	dir string
+}
+
+// defaultSyncManifestDir returns the directory used if no other one is configured.
+func defaultSyncManifestDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "bitrise-mcp", "sync")
+}
+
+// file returns the path of the manifest of a source and destination pair. The
+// name depends on the token too, so tenants of a shared server don't share manifests.
+func (s *syncManifestStore) file(ctx context.Context, machineID, source, destination string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{ownerOf(ctx), machineID, source, destination}, "\x00")))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// load returns the manifest of the last sync, or nil if there is none.
+func (s *syncManifestStore) load(ctx context.Context, machineID, source, destination string) (*syncManifest, error) {
+	data, err := os.ReadFile(s.file(ctx, machineID, source, destination))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read sync manifest: %w", err)
+	}
+	var manifest syncManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		// A corrupt manifest only costs a full sync.
+		return nil, nil
+	}
+	return &manifest, nil
+}
+
+// save stores the manifest, replacing the previous one atomically.
+func (s *syncManifestStore) save(ctx context.Context, manifest *syncManifest) error {
+	s.removeExpired()
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create sync manifest directory: %w", err)
+	}
+	manifest.Owner = ownerOf(ctx)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal sync manifest: %w", err)
+	}
+	file := s.file(ctx, manifest.MachineID, manifest.SourcePath, manifest.Destination)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write sync manifest: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("write sync manifest: %w", err)
+	}
+	return nil
+}
+
+// removeExpired deletes the manifests older than the retention period.
+func (s *syncManifestStore) removeExpired() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		if time.Since(info.ModTime()) > syncManifestRetention {
+			_ = os.Remove(filepath.Join(s.dir, entry.Name()))
+		}
+	}
+}
+
+// scanSyncSource returns the entries of the folder at root the filter doesn't
+// skip. The hash of files whose size and modification time didn't change
+// since the previous manifest is reused instead of reading them again.
+func scanSyncSource(root string, filter *uploadFilter, previous map[string]syncEntry) (map[string]syncEntry, archiveStats, error) {
+	var stats archiveStats
+	entries := make(map[string]syncEntry)
+	err := walkFiltered(root, filter, &stats, func(path, rel string, info fs.FileInfo) error {
+		if rel == "." {
+			return nil
+		}
+		entry := syncEntry{Mode: info.Mode()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("read symlink %s: %w", path, err)
+			}
+			entry.Link = link
+		case info.Mode().IsRegular():
+			entry.Size = info.Size()
+			entry.ModTime = info.ModTime().UTC()
+			if prev, ok := previous[rel]; ok && prev.Mode == entry.Mode && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) {
+				entry.SHA256 = prev.SHA256
+			} else {
+				hash, err := hashFile(path)
+				if err != nil {
+					return err
+				}
+				entry.SHA256 = hash
+			}
+		}
+		entries[rel] = entry
+		return nil
+	})
+	return entries, stats, err
+}
+
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("open file %s: %w", name, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("hash file %s: %w", name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// syncPlan lists what to change on the machine to turn the previously synced
+// state of a folder into the current one.
+type syncPlan struct {
+	// upload are the added and changed paths, sorted so folders come before their content.
+	upload []string
+	// remove are the paths to delete before the upload: the removed paths,
+	// and the ones that changed between file, folder and symlink.
+	remove []string
+	// The number of added, changed, removed and unchanged files and symlinks.
+	added, changed, removed, unchanged int
+}
+
+// planSync compares the previously synced state of a folder with the current
+// one. If full is true, the unchanged paths are uploaded too.
+func planSync(previous, current map[string]syncEntry, full bool) syncPlan {
+	var plan syncPlan
+	// Folders are synced too, but only files and symlinks are counted.
+	count := func(counter *int, entry syncEntry) {
+		if !entry.Mode.IsDir() {
+			*counter++
+		}
+	}
+	for rel, entry := range current {
+		prev, ok := previous[rel]
+		switch {
+		case !ok:
+			count(&plan.added, entry)
+			plan.upload = append(plan.upload, rel)
+		case prev.sameContent(entry):
+			count(&plan.unchanged, entry)
+			if full {
+				plan.upload = append(plan.upload, rel)
+			}
+		default:
+			count(&plan.changed, entry)
+			plan.upload = append(plan.upload, rel)
+			if prev.Mode.Type() != entry.Mode.Type() {
+				plan.remove = append(plan.remove, rel)
+			}
+		}
+	}
+	for rel, prev := range previous {
+		if _, ok := current[rel]; ok {
+			continue
+		}
+		count(&plan.removed, prev)
+		// Removing a folder removes its content too.
+		parent := path.Dir(rel)
+		if _, ok := previous[parent]; ok {
+			if _, ok := current[parent]; !ok {
+				continue
+			}
+		}
+		plan.remove = append(plan.remove, rel)
+	}
+	slices.Sort(plan.upload)
+	slices.Sort(plan.remove)
+	return plan
+}
+
+// maxRemoveBatchBytes bounds the paths deleted by a single command, which is
+// limited in size.
+const maxRemoveBatchBytes = 64 << 10
+
+// syncRemoveScripts returns the scripts that delete paths in the destination
+// folder in batches, and print "missing" if the folder doesn't exist. There is
+// always at least one script, to check the folder.
+func syncRemoveScripts(destination string, paths []string) []string {
+	header := fmt.Sprintf("cd -- %s 2>/dev/null || { echo missing; exit 0; }\n", bitrise.ShellQuote(destination))
+	var scripts []string
+	var args strings.Builder
+	flush := func() {
+		if args.Len() > 0 {
+			scripts = append(scripts, header+"rm -rf --"+args.String()+"\n")
+			args.Reset()
+		}
+	}
+	for _, rel := range paths {
+		arg := " " + bitrise.ShellQuote("./"+rel)
+		if args.Len()+len(arg) > maxRemoveBatchBytes {
+			flush()
+		}
+		args.WriteString(arg)
+	}
+	flush()
+	if len(scripts) == 0 {
+		scripts = append(scripts, header)
+	}
+	return scripts
+}
+
+// writeSyncArchive writes a tar.gz archive of the given paths of root, and
+// counts the archived files and bytes in progress.
+func writeSyncArchive(w io.Writer, root string, paths []string, progress *transferProgress) error {
+	gzWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzWriter)
+	for _, rel := range paths {
+		file := filepath.Join(root, filepath.FromSlash(rel))
+		info, err := os.Lstat(file)
+		if err != nil {
+			tarWriter.Close()
+			gzWriter.Close()
+			return fmt.Errorf("get file info for %s: %w", file, err)
+		}
+		if err := addToTar(tarWriter, file, rel, info, progress); err != nil {
+			tarWriter.Close()
+			gzWriter.Close()
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		gzWriter.Close()
+		return fmt.Errorf("close tar writer: %w", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return fmt.Errorf("close gzip writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package tool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+	remote := func(rel string) string { return m.Path("work/MyApp/" + rel) }
+
+	project := filepath.Join(t.TempDir(), "MyApp")
+	writeFiles(t, project, map[string]string{
+		"Sources/main.swift": "print(\"hello\")\n",
+		"Sources/util.swift": "func util() {}\n",
+		"old/legacy.swift":   "// legacy\n",
+		"README.md":          "# MyApp\n",
+		"build/out.o":        "object",
+	})
+	sync := func(full bool) string {
+		t.Helper()
+		return resultText(h.mustCallTool("bitrise_remote_machine_sync", map[string]any{
+			"machine_id":         id,
+			"source_path":        project,
+			"destination_folder": "work/MyApp",
+			"exclude":            []any{"build"},
+			"full":               full,
+		}))
+	}
+
+	if got := sync(false); !strings.Contains(got, "4 added, 0 changed, 0 deleted, 0 unchanged") || !strings.Contains(got, "Skipped 1 files") {
+		t.Errorf("first sync: %s", got)
+	}
+	assertFile(t, remote("Sources/main.swift"), "print(\"hello\")\n")
+	if _, err := os.Stat(remote("build")); !os.IsNotExist(err) {
+		t.Errorf("excluded folder was synced: %v", err)
+	}
+
+	// Edit, add and remove files, and create a file on the machine.
+	writeFiles(t, project, map[string]string{
+		"Sources/main.swift": "print(\"hello, world\")\n",
+		"Sources/new.swift":  "// new\n",
+	})
+	if err := os.RemoveAll(filepath.Join(project, "old")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, remote(""), map[string]string{"DerivedData/cache": "remote only"})
+
+	if got := sync(false); !strings.Contains(got, "1 added, 1 changed, 1 deleted, 2 unchanged (29 bytes uploaded)") {
+		t.Errorf("incremental sync: %s", got)
+	}
+	assertFile(t, remote("Sources/main.swift"), "print(\"hello, world\")\n")
+	assertFile(t, remote("Sources/new.swift"), "// new\n")
+	assertFile(t, remote("DerivedData/cache"), "remote only")
+	if _, err := os.Stat(remote("old")); !os.IsNotExist(err) {
+		t.Errorf("removed folder still exists on the machine: %v", err)
+	}
+
+	if got := sync(false); !strings.Contains(got, "0 added, 0 changed, 0 deleted") {
+		t.Errorf("sync without changes: %s", got)
+	}
+
+	// A deleted destination is uploaded again.
+	if err := os.RemoveAll(remote("")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sync(false); !strings.Contains(got, "4 added") {
+		t.Errorf("sync to a deleted destination: %s", got)
+	}
+	assertFile(t, remote("README.md"), "# MyApp\n")
+
+	// A full sync resets the files modified on the machine, and still deletes the removed ones.
+	writeFiles(t, remote(""), map[string]string{"README.md": "modified on the machine"})
+	if err := os.Remove(filepath.Join(project, "Sources/util.swift")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sync(true); !strings.Contains(got, "0 added, 0 changed, 1 deleted, 3 unchanged") {
+		t.Errorf("full sync: %s", got)
+	}
+	assertFile(t, remote("README.md"), "# MyApp\n")
+	if _, err := os.Stat(remote("Sources/util.swift")); !os.IsNotExist(err) {
+		t.Errorf("removed file still exists on the machine after a full sync: %v", err)
+	}
+}
+
+func TestSyncRemovesManyFiles(t *testing.T) {
+	h := newHarness(t)
+	id := h.createMachine()
+	m, _ := h.fake.Machine(id)
+
+	// The paths of the removed files are longer than ARG_MAX (2 MiB on Linux) together.
+	project := filepath.Join(t.TempDir(), "MyApp")
+	files := map[string]string{"keep.txt": "keep"}
+	prefix := strings.Repeat("long-file-name-", 13)
+	for i := range 10000 {
+		files[fmt.Sprintf("many/%s%05d", prefix, i)] = ""
+	}
+	writeFiles(t, project, files)
+	sync := func() string {
+		t.Helper()
+		return resultText(h.mustCallTool("bitrise_remote_machine_sync", map[string]any{
+			"machine_id":         id,
+			"source_path":        project,
+			"destination_folder": "work/MyApp",
+		}))
+	}
+	if got := sync(); !strings.Contains(got, "10001 added") {
+		t.Fatalf("first sync: %s", got)
+	}
+
+	// The folder stays, so every file is deleted on its own.
+	entries, err := os.ReadDir(filepath.Join(project, "many"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(project, "many", entry.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sync(); !strings.Contains(got, "0 added, 0 changed, 10000 deleted, 1 unchanged") {
+		t.Errorf("sync removing many files: %s", got)
+	}
+	if left, err := os.ReadDir(m.Path("work/MyApp/many")); err != nil || len(left) != 0 {
+		t.Errorf("%d files left on the machine (%v)", len(left), err)
+	}
+	assertFile(t, m.Path("work/MyApp/keep.txt"), "keep")
+}
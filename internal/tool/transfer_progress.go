@@ -0,0 +1,185 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transferProgressInterval is the minimum time between two progress
+// notifications of a transfer.
+const transferProgressInterval = 500 * time.Millisecond
+
+// transferProgress counts the bytes and files of an upload or a download, and
+// reports them as progress notifications. The counters run even if the client
+// didn't ask for progress, as the tool results are built from them. A nil
+// transferProgress counts nothing.
+type transferProgress struct {
+	ctx      context.Context
+	reporter *progressReporter
+	// upload is true for uploads, where the progress is the archived bytes,
+	// and false for downloads, where it is the received bytes.
+	upload bool
+	// total is the number of bytes the progress goes up to, 0 if unknown.
+	total int64
+	start time.Time
+
+	// archived is the number of bytes of file content written to or read from the tar stream.
+	archived atomic.Int64
+	// transferred is the number of bytes of the archive sent or received.
+	transferred atomic.Int64
+	files       atomic.Int64
+
+	mu           sync.Mutex
+	lastReport   time.Time
+	lastProgress int64
+}
+
+func newTransferProgress(ctx context.Context, reporter *progressReporter, upload bool, total int64) *transferProgress {
+	return &transferProgress{ctx: ctx, reporter: reporter, upload: upload, total: total, start: time.Now()}
+}
+
+// tarWriter returns a writer that counts the file content written to the tar writer w.
+func (p *transferProgress) tarWriter(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressWriter{w: w, n: &p.archived, p: p}
+}
+
+// tarReader returns a reader that counts the file content read from the tar reader r.
+func (p *transferProgress) tarReader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, n: &p.archived, p: p}
+}
+
+// body returns a reader that counts the bytes of the archive read from or
+// for an HTTP body.
+func (p *transferProgress) body(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, n: &p.transferred, p: p}
+}
+
+// addFile counts an archived or extracted file.
+func (p *transferProgress) addFile() {
+	if p != nil {
+		p.files.Add(1)
+	}
+}
+
+// transferredBytes returns the number of bytes of the archive sent or received so far.
+func (p *transferProgress) transferredBytes() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.transferred.Load()
+}
+
+// rewind resets the transferred bytes to n, to not count a failed attempt
+// that is retried.
+func (p *transferProgress) rewind(n int64) {
+	if p != nil {
+		p.transferred.Store(n)
+	}
+}
+
+// elapsed returns the time since the transfer started.
+func (p *transferProgress) elapsed() time.Duration {
+	return time.Since(p.start).Round(time.Millisecond)
+}
+
+// report sends a progress notification, unless one was sent recently or
+// there was no progress since the last one.
+func (p *transferProgress) report() {
+	if !p.reporter.enabled() {
+		return
+	}
+	progress := p.transferred.Load()
+	if p.upload {
+		progress = p.archived.Load()
+	}
+	if p.total > 0 {
+		progress = min(progress, p.total)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if progress <= p.lastProgress || time.Since(p.lastReport) < transferProgressInterval {
+		return
+	}
+	p.lastProgress = progress
+	p.lastReport = time.Now()
+	p.reporter.report(p.ctx, float64(progress), float64(p.total), p.message())
+}
+
+func (p *transferProgress) message() string {
+	throughput := formatBytes(int64(float64(p.transferred.Load())/max(time.Since(p.start).Seconds(), 0.001))) + "/s"
+	if p.upload {
+		return fmt.Sprintf("Uploading: %s%s archived (%d files), %s sent, %s",
+			formatBytes(p.archived.Load()), ofTotal(p.total), p.files.Load(), formatBytes(p.transferred.Load()), throughput)
+	}
+	return fmt.Sprintf("Downloading: %s%s received, %d files (%s) extracted, %s",
+		formatBytes(p.transferred.Load()), ofTotal(p.total), p.files.Load(), formatBytes(p.archived.Load()), throughput)
+}
+
+func ofTotal(total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	return " of " + formatBytes(total)
+}
+
+type progressWriter struct {
+	w io.Writer
+	n *atomic.Int64
+	p *transferProgress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.n.Add(int64(n))
+	w.p.report()
+	return n, err
+}
+
+type progressReader struct {
+	r io.Reader
+	n *atomic.Int64
+	p *transferProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n.Add(int64(n))
+	r.p.report()
+	return n, err
+}
+
+// formatBytes formats a number of bytes with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// compressionRatio formats how many times smaller an archive is than its content.
+func compressionRatio(content, archive int64) string {
+	if content <= 0 || archive <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f:1", float64(content)/float64(archive))
+}
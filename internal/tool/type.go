@@ -2,8 +2,6 @@ package tool
 
 import (
 	"context"
-	"fmt"
-	"net/http"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -59,6 +57,8 @@ NOTES:
 - Do NOT use AppleScript or shell commands for keyboard input - use this tool instead
 - Long text strings are typed sequentially`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to type on"),
 			mcp.Required(),
@@ -68,6 +68,7 @@ NOTES:
 			mcp.Required(),
 		),
 	),
+	Group: bitrise.GroupGUI,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -79,19 +80,9 @@ NOTES:
 			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		body := map[string]any{
-			"text": text,
+		if err := bitrise.Machines(ctx).Type(ctx, machineID, bitrise.TypeRequest{Text: text}); err != nil {
+			return newToolResultAPIError("failed to type text", err), nil
 		}
-
-		res, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    fmt.Sprintf("/platform/me/machines/%s/type", machineID),
-			Body:    body,
-		})
-		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to type text", err), nil
-		}
-		return mcp.NewToolResultText(res), nil
+		return mcp.NewToolResultText(""), nil
 	},
 }
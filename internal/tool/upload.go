@@ -2,10 +2,8 @@ package tool
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -18,11 +16,6 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
-type startUploadResponse struct {
-	SignedURL string `json:"signedUrl"`
-	UploadID  string `json:"uploadId"`
-}
-
 var Upload = bitrise.Tool{
 	Definition: mcp.NewTool("bitrise_remote_machine_upload",
 		mcp.WithDescription(
@@ -56,12 +49,30 @@ PARAMETERS:
   (e.g., "/Users/user/project/", "/tmp/myfiles/").
 - only_contents_of_folder (optional): If true and source_path is a folder, only the contents
   of the folder will be archived and uploaded, not the folder itself. Defaults to false.
+- include (optional): Globs of the files to upload, relative to source_path. If set, only the matching
+  files are uploaded (e.g., ["Sources/**", "*.xcodeproj/**", "Package.swift"]).
+- exclude (optional): Globs of the files and folders not to upload, relative to source_path
+  (e.g., ["DerivedData", "build/**", "Pods", "node_modules", "*.xcarchive"]).
+- respect_gitignore (optional): If true, the files ignored by the .gitignore files at every level of the
+  folder are not uploaded, and neither is the .git folder. Defaults to false. Use it for git repositories,
+  unless an ignored file (e.g. a generated config) is needed for the build.
+
+FILTERING:
+- Globs use .gitignore syntax: a glob without a slash matches file and folder names at any level,
+  "**" matches any number of folders. Excluding a folder excludes everything in it.
+- A .remotemachineignore file, in .gitignore syntax, is always honored at every level of the folder.
+- Filters only apply to folders, a single source file is always uploaded.
+- Skip build outputs and dependencies (DerivedData, build, Pods, node_modules, .build) to make uploads
+  of large projects much faster; they can be rebuilt or reinstalled on the VM.
 
 IMPORTANT NOTES:
-- For folders, the content is compressed as tar.gz before upload and extracted on the VM.
+- For folders, the content is compressed as tar.gz while it is uploaded and extracted on the VM.
 - The destination_parent_folder should be an absolute path on the macOS filesystem.
 - Parent directories will be created automatically if they don't exist.
-- For large files/folders, the upload may take some time.
+- For large files/folders, the upload may take some time. The archive is uploaded in parts, and a part that
+  fails is retried automatically, so only call the tool again if it returned an error.
+- The SHA-256 of the archive is verified on the VM before it is extracted. A mismatch is reported as an error;
+  retry the upload in that case.
 
 ERROR HANDLING:
 - If upload fails, read the error message carefully and retry the upload.
@@ -76,8 +87,19 @@ EXAMPLE USAGE:
 - Upload a project folder for remote build:
   bitrise_remote_machine_upload(machine_id="abc123", source_path="/local/myproject", destination_parent_folder="/Users/user/myproject")
 
-RETURNS: A success message or error details.`,
+- Upload a git repository without its ignored files and build outputs:
+  bitrise_remote_machine_upload(machine_id="abc123", source_path="/local/myproject", destination_parent_folder="/Users/user",
+    respect_gitignore=true, exclude=["DerivedData", "Pods"])
+
+PROGRESS:
+If the request has a progress token, progress notifications report the archived and sent bytes, the number of
+archived files and the throughput while the upload runs.
+
+RETURNS: A success message with the number of uploaded files and bytes, and of the skipped ones, the size and
+compression ratio of the archive and the elapsed time, or error details.`,
 		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
 		mcp.WithString("machine_id",
 			mcp.Description("The unique identifier of the remote machine to upload to"),
 			mcp.Required(),
@@ -93,7 +115,19 @@ RETURNS: A success message or error details.`,
 		mcp.WithBoolean("only_contents_of_folder",
 			mcp.Description("If true and source_path is a folder, only the contents of the folder will be archived and uploaded, not the folder itself"),
 		),
+		mcp.WithArray("include",
+			mcp.Description("Globs of the files to upload, relative to source_path (e.g. \"Sources/**/*.swift\"). All files are uploaded if empty"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Globs of the files and folders not to upload, relative to source_path (e.g. \"DerivedData\", \"build/**\", \"*.xcarchive\")"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithBoolean("respect_gitignore",
+			mcp.Description("If true, the files ignored by the .gitignore files of the folder and the .git folder are not uploaded"),
+		),
 	),
+	Group: bitrise.GroupTransfer,
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		machineID, err := request.RequireString("machine_id")
 		if err != nil {
@@ -111,6 +145,14 @@ RETURNS: A success message or error details.`,
 		}
 
 		onlyContentsOfFolder := request.GetBool("only_contents_of_folder", false)
+		filter, err := newUploadFilter(
+			request.GetStringSlice("include", nil),
+			request.GetStringSlice("exclude", nil),
+			request.GetBool("respect_gitignore", false),
+		)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		// Check if source exists (use Lstat to not follow symlinks)
 		sourceInfo, err := os.Lstat(sourcePath)
@@ -118,59 +160,44 @@ RETURNS: A success message or error details.`,
 			return mcp.NewToolResultError(fmt.Sprintf("failed to access source path: %v", err)), nil
 		}
 
-		// Step 1: Start upload to get signed URL and upload ID
-		startRes, err := bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    "/platform/me/machines/" + machineID + "/start_upload",
-		})
-		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to start upload", err), nil
-		}
-
-		var startResp startUploadResponse
-		if err := json.Unmarshal([]byte(startRes), &startResp); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to parse start upload response: %v", err)), nil
-		}
-
-		// Step 2: Create tar.gz archive
-		var buf bytes.Buffer
-		if err := createTarGz(&buf, sourcePath, sourceInfo, onlyContentsOfFolder); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to create tar.gz archive: %v", err)), nil
+		// Stream the tar.gz archive to the VM in parts while it is created
+		var total int64
+		reporter := newProgressReporter(ctx, request)
+		if reporter.enabled() {
+			total = sourceBytes(sourcePath, sourceInfo, filter.fresh())
 		}
-
-		// Step 3: Upload to signed URL
-		if err := uploadToSignedURL(ctx, startResp.SignedURL, buf.Bytes()); err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to upload to signed URL: %v", err)), nil
-		}
-
-		// Step 4: Complete upload
-		completeBody := map[string]any{
-			"uploadId":                startResp.UploadID,
-			"destinationParentFolder": destinationParentFolder,
-		}
-
-		_, err = bitrise.CallAPI(ctx, bitrise.CallAPIParams{
-			Method:  http.MethodPost,
-			BaseURL: bitrise.APIBaseURL(),
-			Path:    "/platform/me/machines/" + machineID + "/complete_upload",
-			Body:    completeBody,
+		progress := newTransferProgress(ctx, reporter, true, total)
+		var stats archiveStats
+		settings := transferSettingsFromContext(ctx)
+		upload, err := uploadArchive(ctx, machineID, destinationParentFolder, settings.partBytes, progress, func(w io.Writer) (err error) {
+			stats, err = createTarGz(w, sourcePath, sourceInfo, onlyContentsOfFolder, filter, progress)
+			return err
 		})
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to complete upload", err), nil
+			return newToolResultAPIError("failed to upload", err), nil
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("Successfully uploaded %s to %s on machine %s", sourcePath, destinationParentFolder, machineID)), nil
+		msg := fmt.Sprintf("Successfully uploaded %s to %s on machine %s (%d files, %d bytes)",
+			sourcePath, destinationParentFolder, machineID, stats.files, stats.bytes)
+		if stats.skippedFiles > 0 {
+			msg += fmt.Sprintf("\nSkipped %d files (%d bytes) matching the exclude globs or ignore files, or not matching the include globs.",
+				stats.skippedFiles, stats.skippedBytes)
+		}
+		msg += "\n" + upload.summary(stats.bytes)
+		return mcp.NewToolResultText(msg), nil
 	},
 }
 
 // createTarGz creates a tar.gz archive.
 // If onlyContentsOfFolder is true and sourcePath is a directory, only the contents are archived.
 // If onlyContentsOfFolder is false and sourcePath is a directory, the directory itself is included.
-func createTarGz(buf *bytes.Buffer, sourcePath string, sourceInfo os.FileInfo, onlyContentsOfFolder bool) error {
-	gzWriter := gzip.NewWriter(buf)
+// The files of a directory the filter skips are counted in the returned stats, but not archived.
+// The archived files and bytes are counted in progress as they are written.
+func createTarGz(w io.Writer, sourcePath string, sourceInfo os.FileInfo, onlyContentsOfFolder bool, filter *uploadFilter, progress *transferProgress) (archiveStats, error) {
+	gzWriter := gzip.NewWriter(w)
 	tarWriter := tar.NewWriter(gzWriter)
 
+	var stats archiveStats
 	var err error
 	if sourceInfo.IsDir() {
 		// Determine the base path for computing relative paths in the archive
@@ -183,17 +210,7 @@ func createTarGz(buf *bytes.Buffer, sourcePath string, sourceInfo os.FileInfo, o
 			basePath = filepath.Dir(sourcePath)
 		}
 
-		err = filepath.WalkDir(sourcePath, func(path string, d fs.DirEntry, walkErr error) error {
-			if walkErr != nil {
-				return walkErr
-			}
-
-			// Get file info (Lstat behavior - doesn't follow symlinks)
-			info, err := d.Info()
-			if err != nil {
-				return fmt.Errorf("get file info for %s: %w", path, err)
-			}
-
+		err = walkFiltered(sourcePath, filter, &stats, func(path, _ string, info fs.FileInfo) error {
 			// Get relative path from basePath
 			relPath, err := filepath.Rel(basePath, path)
 			if err != nil {
@@ -205,38 +222,45 @@ func createTarGz(buf *bytes.Buffer, sourcePath string, sourceInfo os.FileInfo, o
 				return nil
 			}
 
-			// Skip non-regular files (devices, sockets, etc.) except dirs and symlinks
-			mode := info.Mode()
-			if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
-				return nil // Skip special files
-			}
-
-			return addToTar(tarWriter, path, relPath, info)
+			return addToTar(tarWriter, path, relPath, info, progress)
 		})
 	} else {
 		// Single file - use the base name as the archive path
-		err = addToTar(tarWriter, sourcePath, filepath.Base(sourcePath), sourceInfo)
+		stats.add(sourceInfo)
+		err = addToTar(tarWriter, sourcePath, filepath.Base(sourcePath), sourceInfo, progress)
 	}
 
 	if err != nil {
 		tarWriter.Close()
 		gzWriter.Close()
-		return err
+		return stats, err
 	}
 
 	// Close in correct order: tar first, then gzip
 	if err := tarWriter.Close(); err != nil {
 		gzWriter.Close()
-		return fmt.Errorf("close tar writer: %w", err)
+		return stats, fmt.Errorf("close tar writer: %w", err)
 	}
 	if err := gzWriter.Close(); err != nil {
-		return fmt.Errorf("close gzip writer: %w", err)
+		return stats, fmt.Errorf("close gzip writer: %w", err)
 	}
 
-	return nil
+	return stats, nil
+}
+
+// sourceBytes returns the size of the files of sourcePath the filter doesn't
+// skip, which is the total of the upload progress.
+func sourceBytes(sourcePath string, sourceInfo os.FileInfo, filter *uploadFilter) int64 {
+	var stats archiveStats
+	if !sourceInfo.IsDir() {
+		stats.add(sourceInfo)
+		return stats.bytes
+	}
+	_ = walkFiltered(sourcePath, filter, &stats, func(string, string, fs.FileInfo) error { return nil })
+	return stats.bytes
 }
 
-func addToTar(tarWriter *tar.Writer, filePath, archivePath string, info os.FileInfo) error {
+func addToTar(tarWriter *tar.Writer, filePath, archivePath string, info os.FileInfo, progress *transferProgress) error {
 	// Handle symlinks
 	var link string
 	if info.Mode()&os.ModeSymlink != 0 {
@@ -271,18 +295,22 @@ func addToTar(tarWriter *tar.Writer, filePath, archivePath string, info os.FileI
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(tarWriter, file); err != nil {
+	if _, err := io.Copy(progress.tarWriter(tarWriter), file); err != nil {
 		return fmt.Errorf("write file content %s: %w", filePath, err)
 	}
+	progress.addFile()
 
 	return nil
 }
 
-func uploadToSignedURL(ctx context.Context, signedURL string, data []byte) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, signedURL, bytes.NewReader(data))
+// uploadToSignedURL uploads size bytes read from body. The size is set
+// explicitly, as signed URLs don't accept chunked uploads.
+func uploadToSignedURL(ctx context.Context, signedURL string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, signedURL, body)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
+	req.ContentLength = size
 
 	client := http.Client{Timeout: 10 * time.Minute}
 	resp, err := client.Do(req)
@@ -0,0 +1,305 @@
+package tool
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// remoteMachineIgnoreFile lists the files not to upload, in .gitignore syntax.
+// It is honored in every directory of an uploaded folder.
+const remoteMachineIgnoreFile = ".remotemachineignore"
+
+// uploadFilter selects the files of a folder to upload.
+type uploadFilter struct {
+	// include, if not empty, limits the upload to the files matching one of the globs.
+	include []string
+	// exclude skips the files and folders matching one of the globs.
+	exclude []string
+	// gitignore honors the .gitignore files and skips the .git folder.
+	gitignore bool
+	// rules are the patterns of the ignore files read so far.
+	rules []ignoreRule
+}
+
+// newUploadFilter validates the globs and returns a filter.
+func newUploadFilter(include, exclude []string, gitignore bool) (*uploadFilter, error) {
+	for _, glob := range slices.Concat(include, exclude) {
+		if err := validateGlob(glob); err != nil {
+			return nil, err
+		}
+	}
+	return &uploadFilter{include: include, exclude: exclude, gitignore: gitignore}, nil
+}
+
+// fresh returns a filter with the same globs, which hasn't read any ignore
+// files yet, to walk the folder again.
+func (f *uploadFilter) fresh() *uploadFilter {
+	if f == nil {
+		return nil
+	}
+	return &uploadFilter{include: f.include, exclude: f.exclude, gitignore: f.gitignore}
+}
+
+// skip reports whether a file or folder at rel (slash separated, relative
+// to the uploaded folder) is left out of the upload.
+func (f *uploadFilter) skip(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	if f.gitignore && isDir && path.Base(rel) == ".git" {
+		return true
+	}
+	ignored := false
+	for _, rule := range f.rules {
+		if rule.match(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	if ignored || matchAnyGlob(f.exclude, rel) {
+		return true
+	}
+	return !isDir && len(f.include) > 0 && !matchAnyGlob(f.include, rel)
+}
+
+// includesOnly reports whether only the files matching the include globs are uploaded.
+func (f *uploadFilter) includesOnly() bool {
+	return f != nil && len(f.include) > 0
+}
+
+// enter reads the ignore files of the folder at rel ("." for the uploaded folder).
+func (f *uploadFilter) enter(dir, rel string) error {
+	if f == nil {
+		return nil
+	}
+	base := ""
+	if rel != "." {
+		base = rel
+	}
+	names := []string{remoteMachineIgnoreFile}
+	if f.gitignore {
+		names = []string{".gitignore", remoteMachineIgnoreFile}
+	}
+	for _, name := range names {
+		rules, err := readIgnoreFile(filepath.Join(dir, name), base)
+		if err != nil {
+			return err
+		}
+		f.rules = append(f.rules, rules...)
+	}
+	return nil
+}
+
+// walkFiltered walks the folder at root like filepath.WalkDir, and calls fn
+// with the files, folders and symlinks the filter doesn't skip, including the
+// root folder itself (with rel "."). rel is slash separated and relative to
+// root. Other kinds of files, like sockets, are left out. Skipped and passed
+// files are counted in stats.
+func walkFiltered(root string, filter *uploadFilter, stats *archiveStats, fn func(path, rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		// Get file info (Lstat behavior - doesn't follow symlinks)
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("get file info for %s: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && filter.skip(rel, d.IsDir()) {
+			if d.IsDir() {
+				stats.addSkippedDir(path)
+				return filepath.SkipDir
+			}
+			stats.addSkipped(info)
+			return nil
+		}
+		if d.IsDir() {
+			if err := filter.enter(path, rel); err != nil {
+				return err
+			}
+		}
+
+		// Skip non-regular files (devices, sockets, etc.) except dirs and symlinks
+		mode := info.Mode()
+		if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		// With include globs, only the folders of included files are created
+		if mode.IsDir() && rel != "." && filter.includesOnly() {
+			return nil
+		}
+
+		stats.add(info)
+		return fn(path, rel, info)
+	})
+}
+
+// ignoreRule is a pattern of a .gitignore or .remotemachineignore file.
+type ignoreRule struct {
+	// base is the folder of the ignore file, relative to the uploaded folder.
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func readIgnoreFile(name, base string) ([]ignoreRule, error) {
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open ignore file: %w", err)
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A pattern with a slash is relative to the folder of the ignore file,
+		// otherwise it matches the name at any level below it.
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern == "" || validateGlob(rule.pattern) != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ignore file %s: %w", name, err)
+	}
+	return rules, nil
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if !r.anchored {
+		return matchGlob(r.pattern, path.Base(rel))
+	}
+	return matchGlob(r.pattern, rel)
+}
+
+// matchAnyGlob reports whether rel matches one of the globs. Globs without a
+// slash match the name of files and folders at any level, like in .gitignore.
+func matchAnyGlob(globs []string, rel string) bool {
+	for _, glob := range globs {
+		glob = strings.TrimPrefix(strings.TrimSuffix(glob, "/"), "/")
+		if !strings.Contains(glob, "/") {
+			if matchGlob(glob, path.Base(rel)) {
+				return true
+			}
+			continue
+		}
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob, in which "**"
+// matches any number of folders.
+func matchGlob(glob, name string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func validateGlob(glob string) error {
+	for segment := range strings.SplitSeq(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// archiveStats counts the uploaded and the skipped files.
+type archiveStats struct {
+	files        int
+	bytes        int64
+	skippedFiles int
+	skippedBytes int64
+}
+
+func (s *archiveStats) add(info fs.FileInfo) {
+	if info.Mode().IsRegular() {
+		s.files++
+		s.bytes += info.Size()
+	}
+}
+
+func (s *archiveStats) addSkipped(info fs.FileInfo) {
+	if info.Mode().IsRegular() {
+		s.skippedFiles++
+		s.skippedBytes += info.Size()
+	}
+}
+
+// addSkippedDir counts the files in a skipped folder.
+func (s *archiveStats) addSkippedDir(dir string) {
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			s.addSkipped(info)
+		}
+		return nil
+	})
+}
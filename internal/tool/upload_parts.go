@@ -0,0 +1,241 @@
+package tool
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+)
+
+// uploadPartAttempts is how many times a part is tried to be uploaded.
+const uploadPartAttempts = 5
+
+// uploadRetryBackoff is the wait before the first retry of a part. It doubles
+// with every further retry.
+var uploadRetryBackoff = time.Second //nolint:gochecknoglobals
+
+// checksumMismatchExitCode is the exit code of the assemble script if the
+// SHA-256 of the joined parts doesn't match the one of the archive.
+const checksumMismatchExitCode = 3
+
+var (
+	errUploadAborted    = errors.New("upload aborted")
+	errChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// uploadResult describes an uploaded archive.
+type uploadResult struct {
+	archiveBytes int64
+	sha256       string
+	parts        int
+	retries      int
+	elapsed      time.Duration
+}
+
+// summary describes the upload of an archive of contentBytes of files for the tool results.
+func (r *uploadResult) summary(contentBytes int64) string {
+	s := fmt.Sprintf("The archive (%d bytes, compression ratio %s, SHA-256 %s) was uploaded in %d part(s) in %s and verified on the machine.",
+		r.archiveBytes, compressionRatio(contentBytes, r.archiveBytes), r.sha256, r.parts, r.elapsed)
+	if r.retries > 0 {
+		s += fmt.Sprintf(" %d failed part upload(s) were retried.", r.retries)
+	}
+	return s
+}
+
+// uploadArchive uploads the tar.gz archive written by write to the machine,
+// and extracts it in destination.
+//
+// The archive is split into parts while it is written, so memory use is
+// bounded by the part size, whatever the size of the archive is. Every part is
+// uploaded with its own start_upload and complete_upload calls into a staging
+// folder inside destination, and is retried on its own if it fails. Once all
+// parts arrived, an execute call joins them, verifies the SHA-256 of the
+// archive and extracts it. The sent bytes are counted in progress.
+func uploadArchive(ctx context.Context, machineID, destination string, partBytes int, progress *transferProgress, write func(w io.Writer) error) (*uploadResult, error) {
+	start := time.Now()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generate upload ID: %w", err)
+	}
+	staging := ".bitrise-mcp-upload-" + hex.EncodeToString(b)
+
+	pr, pw := io.Pipe()
+	archiveErr := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		archiveErr <- err
+	}()
+
+	machines := bitrise.Machines(ctx)
+	result, uploadErr := uploadParts(ctx, machines, machineID, path.Join(destination, staging), partBytes, progress, pr)
+	// Unblock the archiver if the upload stopped before reading everything.
+	pr.CloseWithError(errUploadAborted)
+
+	// A failing archiver fails the upload too, so it's the root cause.
+	if err := <-archiveErr; err != nil && !errors.Is(err, errUploadAborted) {
+		uploadErr = fmt.Errorf("create tar.gz archive: %w", err)
+	}
+	if uploadErr != nil {
+		removeStagingFolder(ctx, machines, machineID, destination, staging)
+		return nil, uploadErr
+	}
+
+	res, err := machines.Run(ctx, machineID, bitrise.Command{Script: assembleScript(destination, staging, result.sha256)})
+	if err != nil {
+		return nil, fmt.Errorf("extract archive: %w", err)
+	}
+	switch res.ExitCode {
+	case 0:
+		result.elapsed = time.Since(start).Round(time.Millisecond)
+		return result, nil
+	case checksumMismatchExitCode:
+		return nil, fmt.Errorf("%w: %s", errChecksumMismatch, strings.TrimSpace(res.Stderr))
+	default:
+		return nil, fmt.Errorf("extract archive: exit code %d: %s", res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+}
+
+// uploadParts reads r in parts of partBytes, and uploads them into the staging folder.
+func uploadParts(ctx context.Context, machines *bitrise.MachinesAPI, machineID, staging string, partBytes int, progress *transferProgress, r io.Reader) (*uploadResult, error) {
+	result := &uploadResult{}
+	hash := sha256.New()
+	reader := io.TeeReader(r, hash)
+	buf := make([]byte, partBytes)
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return nil, readErr
+		}
+		if n > 0 {
+			retries, err := uploadPartWithRetries(ctx, machines, machineID, staging, result.parts, buf[:n], progress)
+			if err != nil {
+				return nil, fmt.Errorf("upload part %d: %w", result.parts+1, err)
+			}
+			result.parts++
+			result.retries += retries
+			result.archiveBytes += int64(n)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	result.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return result, nil
+}
+
+// uploadPartWithRetries uploads a part, and retries it with exponential
+// backoff if it fails. It returns the number of retries.
+func uploadPartWithRetries(ctx context.Context, machines *bitrise.MachinesAPI, machineID, staging string, index int, data []byte, progress *transferProgress) (int, error) {
+	backoff := uploadRetryBackoff
+	sent := progress.transferredBytes()
+	for attempt := 1; ; attempt++ {
+		err := uploadPart(ctx, machines, machineID, staging, index, data, progress)
+		if err == nil {
+			return attempt - 1, nil
+		}
+		progress.rewind(sent)
+		if attempt == uploadPartAttempts || !retryableUploadError(err) {
+			return attempt - 1, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt - 1, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableUploadError reports whether retrying a failed part can help.
+func retryableUploadError(err error) bool {
+	return !errors.Is(err, bitrise.ErrMissingPAT) &&
+		!errors.Is(err, bitrise.ErrUnauthorized) &&
+		!errors.Is(err, bitrise.ErrNotFound) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// uploadPart uploads a part as a file named part-<index> into the staging
+// folder. As the upload endpoints extract tar.gz archives, the part is
+// wrapped in one, without compressing it again.
+func uploadPart(ctx context.Context, machines *bitrise.MachinesAPI, machineID, staging string, index int, data []byte, progress *transferProgress) error {
+	var buf bytes.Buffer
+	gzWriter, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+	if err != nil {
+		return fmt.Errorf("create gzip writer: %w", err)
+	}
+	tarWriter := tar.NewWriter(gzWriter)
+	header := &tar.Header{
+		Name:     fmt.Sprintf("part-%06d", index),
+		Typeflag: tar.TypeReg,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("write part header: %w", err)
+	}
+	if _, err := tarWriter.Write(data); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("close tar writer: %w", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return fmt.Errorf("close gzip writer: %w", err)
+	}
+
+	startResp, err := machines.StartUpload(ctx, machineID)
+	if err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+	if err := uploadToSignedURL(ctx, startResp.SignedURL, progress.body(bytes.NewReader(buf.Bytes())), int64(buf.Len())); err != nil {
+		return fmt.Errorf("upload to signed URL: %w", err)
+	}
+	err = machines.CompleteUpload(ctx, machineID, bitrise.CompleteUploadRequest{
+		UploadID:                startResp.UploadID,
+		DestinationParentFolder: staging,
+	})
+	if err != nil {
+		return fmt.Errorf("complete upload: %w", err)
+	}
+	return nil
+}
+
+// assembleScript returns a script that joins the parts in the staging folder,
+// verifies their SHA-256, and extracts them in destination.
+func assembleScript(destination, staging, sum string) string {
+	return fmt.Sprintf(`cd -- %s || exit 1
+s=%s
+cat "$s"/part-* >"$s/archive.tar.gz" && rm -f "$s"/part-* || { rm -rf "$s"; exit 1; }
+if command -v shasum >/dev/null 2>&1; then sum=$(shasum -a 256 <"$s/archive.tar.gz"); else sum=$(sha256sum <"$s/archive.tar.gz"); fi
+sum=${sum%%%% *}
+if [ "$sum" != %s ]; then
+  echo "the SHA-256 of the uploaded archive is $sum instead of %s" >&2
+  rm -rf "$s"
+  exit %d
+fi
+tar -xzf "$s/archive.tar.gz"
+c=$?
+rm -rf "$s"
+exit $c`, bitrise.ShellQuote(destination), bitrise.ShellQuote(staging), sum, sum, checksumMismatchExitCode)
+}
+
+// removeStagingFolder deletes the parts of a failed upload.
+func removeStagingFolder(ctx context.Context, machines *bitrise.MachinesAPI, machineID, destination, staging string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	script := fmt.Sprintf("cd -- %s 2>/dev/null && rm -rf %s", bitrise.ShellQuote(destination), bitrise.ShellQuote(staging))
+	_, _ = machines.Run(ctx, machineID, bitrise.Command{Script: script})
+}
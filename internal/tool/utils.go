@@ -1,9 +1,13 @@
 package tool
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"runtime"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
 // openURL opens the given URL or file with the system's default application
@@ -23,3 +27,47 @@ func openURL(urlOrPath string) error {
 
 	return cmd.Run()
 }
+
+// newToolResultAPIError returns a tool error for a failed Bitrise API call,
+// with a hint on how to recover from the known kinds of errors.
+func newToolResultAPIError(text string, err error) *mcp.CallToolResult {
+	var hint string
+	switch {
+	case errors.Is(err, bitrise.ErrMissingPAT), errors.Is(err, bitrise.ErrUnauthorized):
+		hint = "The Bitrise personal access token is missing or invalid. Ask the user to check it; retrying will not help."
+	case errors.Is(err, bitrise.ErrNotFound):
+		hint = "The machine does not exist (anymore). Call bitrise_remote_machine_list to get the ID of a running machine, or bitrise_remote_machine_create if there is none."
+	case errors.Is(err, bitrise.ErrQuotaExceeded):
+		hint = "Only one machine can run at a time. Call bitrise_remote_machine_list and reuse the existing machine instead of creating a new one."
+	case errors.Is(err, bitrise.ErrJobNotFound):
+		hint = "The job does not exist on this machine. Check the job_id returned by bitrise_remote_machine_job_start and the machine_id it was started on."
+	case errors.Is(err, bitrise.ErrMachineNotReady):
+		hint = "The machine is still starting up. Call bitrise_remote_machine_wait_for_ready with its machine_id, then retry the same call."
+	}
+	res := mcp.NewToolResultErrorFromErr(text, err)
+	if hint != "" {
+		res.Content = append(res.Content, mcp.NewTextContent(hint))
+	}
+	return res
+}
+
+// stringMapArgument returns an optional object argument with string values.
+func stringMapArgument(request mcp.CallToolRequest, key string) (map[string]string, error) {
+	raw, ok := request.GetArguments()[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	object, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("argument %q must be an object", key)
+	}
+	values := make(map[string]string, len(object))
+	for name, value := range object {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of %q in argument %q must be a string", name, key)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
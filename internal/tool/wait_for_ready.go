@@ -0,0 +1,185 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultReadyTimeout is how long machines are waited for by default.
+const defaultReadyTimeout = 5 * time.Minute
+
+// maxReadyTimeoutSeconds is the longest a machine can be waited for.
+const maxReadyTimeoutSeconds = 1800
+
+var WaitForReady = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_wait_for_ready",
+		mcp.WithDescription(
+			`Wait until a remote macOS virtual machine has booted and can be used: SSH accepts commands, the shell
+works and the GUI session is running.
+
+PURPOSE:
+Use this tool after bitrise_remote_machine_create (or call create with wait set to true), so a long boot
+(typically 30-60 seconds) is not mistaken for a hung command, and the GUI tools don't fail on a machine
+without a desktop yet.
+
+PARAMETERS:
+- machine_id (required): The VM to wait for.
+- timeout_seconds (optional): How long to wait, up to 1800 seconds. Defaults to 300.
+
+PROGRESS:
+If the request carries a progress token, a progress notification is sent after every check, with the
+state of the machine and which of SSH, shell and GUI are available.
+
+NOTES:
+- The machine is checked with increasing intervals, so the call returns a few seconds after it is ready.
+- If the timeout passes first, ready is false and timed_out is true: call the tool again to keep waiting,
+  or check the state with bitrise_remote_machine_describe.
+- A terminating machine is reported as an error: create a new one.
+
+RETURNS: machine_id, ready, timed_out, state, ssh, shell, gui, waited_seconds and boot_seconds (how long the
+machine took to become ready after it was created, if it became ready during the call).`,
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOutputSchema[readiness](),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to wait for"),
+			mcp.Required(),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("How long to wait for the machine, in seconds"),
+			mcp.Min(1),
+			mcp.Max(maxReadyTimeoutSeconds),
+		),
+	),
+	Group: bitrise.GroupLifecycle,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		timeoutSeconds := min(max(request.GetInt("timeout_seconds", int(defaultReadyTimeout.Seconds())), 1), maxReadyTimeoutSeconds)
+		result, err := waitForReady(ctx, machineID, time.Duration(timeoutSeconds)*time.Second, newProgressReporter(ctx, request))
+		if err != nil {
+			return newToolResultAPIError("failed to wait for remote machine", err), nil
+		}
+		return mcp.NewToolResultStructuredOnly(result), nil
+	},
+}
+
+// readiness is the result of waiting for a machine to boot.
+type readiness struct {
+	MachineID     string  `json:"machine_id" jsonschema:"description=The ID of the machine"`
+	Ready         bool    `json:"ready" jsonschema:"description=True if SSH\\, the shell and the GUI session are all available"`
+	TimedOut      bool    `json:"timed_out" jsonschema:"description=True if the timeout passed before the machine was ready"`
+	State         string  `json:"state" jsonschema:"enum=provisioning,enum=booting,enum=ready,enum=terminating,description=The lifecycle state at the last check"`
+	SSH           bool    `json:"ssh" jsonschema:"description=True if the machine accepts commands over SSH"`
+	Shell         bool    `json:"shell" jsonschema:"description=True if commands run in the shell of the user"`
+	GUI           bool    `json:"gui" jsonschema:"description=True if the GUI session of the user is running\\, which the GUI tools need"`
+	WaitedSeconds float64 `json:"waited_seconds" jsonschema:"description=How long the call waited\\, in seconds"`
+	BootSeconds   float64 `json:"boot_seconds,omitempty" jsonschema:"description=How long the machine took to become ready after it was created\\, in seconds. Only set if it became ready during the call"`
+}
+
+func (r readiness) message(elapsed time.Duration) string {
+	yesNo := func(ok bool) string {
+		if ok {
+			return "yes"
+		}
+		return "no"
+	}
+	return fmt.Sprintf("Machine %s is %s: SSH %s, shell %s, GUI %s (waiting for %s)",
+		r.MachineID, r.State, yesNo(r.SSH), yesNo(r.Shell), yesNo(r.GUI), elapsed.Round(time.Second))
+}
+
+// errMachineTerminating is returned when a machine that is waited for is being deleted.
+var errMachineTerminating = errors.New("the machine is terminating. Create a new one with bitrise_remote_machine_create")
+
+// waitForReady checks the machine with exponential backoff until SSH, the
+// shell and the GUI session are available, or the timeout passes. A timeout
+// is not an error, it is reported in the result.
+func waitForReady(ctx context.Context, machineID string, timeout time.Duration, progress *progressReporter) (readiness, error) {
+	settings := readySettingsFromContext(ctx)
+	start := time.Now()
+	deadline := start.Add(timeout)
+	interval := settings.minInterval
+	result := readiness{MachineID: machineID}
+
+	for check := 0; ; check++ {
+		machine, err := checkReadiness(ctx, &result)
+		if err != nil {
+			return result, err
+		}
+		elapsed := time.Since(start)
+		result.WaitedSeconds = roundSeconds(elapsed)
+		if result.Ready {
+			// The boot time is only known if the machine wasn't ready yet at the first check.
+			if check > 0 && !machine.CreatedAt.IsZero() {
+				result.BootSeconds = roundSeconds(time.Since(machine.CreatedAt))
+			}
+			return result, nil
+		}
+		if result.State == bitrise.MachineStateTerminating {
+			return result, errMachineTerminating
+		}
+		progress.report(ctx, elapsed.Seconds(), timeout.Seconds(), result.message(elapsed))
+
+		wait := min(interval, time.Until(deadline))
+		if wait <= 0 {
+			result.TimedOut = true
+			return result, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		case <-timer.C:
+		}
+		interval = min(interval*2, settings.maxInterval)
+	}
+}
+
+// readinessScript prints "shell" if it runs, and "gui" if the GUI session of
+// the user is running.
+const readinessScript = `echo shell
+if launchctl print "gui/$(id -u)" >/dev/null 2>&1; then echo gui; fi`
+
+// checkReadiness updates the result with the state of the machine, and which
+// of SSH, the shell and the GUI session are available.
+func checkReadiness(ctx context.Context, result *readiness) (*bitrise.Machine, error) {
+	result.SSH, result.Shell, result.GUI, result.Ready = false, false, false, false
+	machine, err := bitrise.Machines(ctx).Get(ctx, result.MachineID)
+	if err != nil {
+		return nil, err
+	}
+	result.State = machine.State
+	if machine.State != bitrise.MachineStateReady {
+		return machine, nil
+	}
+
+	res, err := bitrise.Machines(ctx).Run(ctx, result.MachineID, bitrise.Command{Script: readinessScript, Timeout: 30 * time.Second})
+	if errors.Is(err, bitrise.ErrMachineNotReady) {
+		return machine, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.SSH = true
+	result.Shell = res.ExitCode == 0 && strings.Contains(res.Stdout, "shell")
+	result.GUI = strings.Contains(res.Stdout, "gui")
+	result.Ready = result.SSH && result.Shell && result.GUI
+	return machine, nil
+}
+
+func roundSeconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*10) / 10
+}
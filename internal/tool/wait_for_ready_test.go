@@ -0,0 +1,72 @@
+package tool
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/fakeapi"
+)
+
+func TestWaitForReady(t *testing.T) {
+	h := newHarness(t,
+		withFakeOptions(fakeapi.WithBootTime(1500*time.Millisecond)),
+		withBeltOptions(WithReadyPolling(20*time.Millisecond, 100*time.Millisecond)),
+	)
+	id := h.createMachine()
+
+	res := h.callTool("bitrise_remote_machine_execute", map[string]any{"machine_id": id, "bash_command": "true"})
+	if !res.IsError || !strings.Contains(resultText(res), "Call bitrise_remote_machine_wait_for_ready") {
+		t.Errorf("execute on a booting machine: got %q", resultText(res))
+	}
+
+	var got readiness
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_wait_for_ready", map[string]any{"machine_id": id, "timeout_seconds": 1}), &got)
+	if got.Ready || !got.TimedOut || got.State == bitrise.MachineStateReady || got.SSH || got.WaitedSeconds < 1 {
+		t.Errorf("got %+v, want a timeout while booting", got)
+	}
+
+	got = readiness{}
+	decodeStructured(t, h.callToolWithMeta("bitrise_remote_machine_wait_for_ready", map[string]any{"machine_id": id},
+		map[string]any{"progressToken": "boot"}), &got)
+	if !got.Ready || got.TimedOut || got.State != bitrise.MachineStateReady || !got.SSH || !got.Shell || !got.GUI || got.BootSeconds < 1.5 {
+		t.Errorf("got %+v, want a ready machine with its boot time", got)
+	}
+	lastProgress := -1.0
+	var messages []string
+	for _, n := range h.notifications() {
+		if n.Method != methodNotificationProgress {
+			continue
+		}
+		params := n.Params.AdditionalFields
+		progress, _ := params["progress"].(float64)
+		if params["progressToken"] != "boot" || progress <= lastProgress || params["total"] != defaultReadyTimeout.Seconds() {
+			t.Errorf("got progress %v", params)
+		}
+		lastProgress = progress
+		message, _ := params["message"].(string)
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 || !strings.Contains(messages[0], "is booting: SSH no, shell no, GUI no") {
+		t.Errorf("got progress messages %q", messages)
+	}
+
+	got = readiness{}
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_wait_for_ready", map[string]any{"machine_id": id}), &got)
+	if !got.Ready || got.BootSeconds != 0 {
+		t.Errorf("got %+v, want a ready machine without boot time", got)
+	}
+
+	h.mustCallTool("bitrise_remote_machine_delete", map[string]any{"machine_id": id})
+	var created createResult
+	decodeStructured(t, h.mustCallTool("bitrise_remote_machine_create", map[string]any{"wait": true}), &created)
+	if created.MachineID == "" || created.Readiness == nil || !created.Readiness.Ready || created.Readiness.BootSeconds < 1.5 {
+		t.Errorf("create with wait: got %+v", created)
+	}
+
+	res = h.callTool("bitrise_remote_machine_wait_for_ready", map[string]any{"machine_id": "missing"})
+	if !res.IsError || !strings.Contains(resultText(res), "bitrise_remote_machine_list") {
+		t.Errorf("wait for missing machine: got %q", resultText(res))
+	}
+}
@@ -0,0 +1,148 @@
+package tool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var WriteFile = bitrise.Tool{
+	Definition: mcp.NewTool("bitrise_remote_machine_write_file",
+		mcp.WithDescription(
+			`Write a small text file on the remote macOS virtual machine from a string.
+
+PURPOSE:
+Use this tool to create or replace configuration files, scripts or small source files on the VM
+(e.g. an ExportOptions.plist, a .xcconfig or a helper script) without an upload round trip, and instead of
+echo or heredocs with bitrise_remote_machine_execute.
+
+PARAMETERS:
+- machine_id (required): The VM to write the file on.
+- path (required): The path of the file on the VM. Relative paths are relative to the home directory.
+  Missing parent folders are created.
+- content (required): The content of the file. An existing file is replaced.
+- mode (optional): The permission bits in octal (e.g. "0755" for scripts). The mode of an existing file is
+  kept, and new files get the default mode (usually 0644), if empty.
+
+LIMITS:
+- The content can be at most 256 KiB. Use bitrise_remote_machine_upload for larger files, for binary files
+  and for folders.
+
+RETURNS: A success message with the number of written bytes, or error details.`,
+		),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithString("machine_id",
+			mcp.Description("The unique identifier of the remote machine to write the file on"),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("The path of the file on the VM, relative to the home directory if not absolute"),
+			mcp.Required(),
+		),
+		mcp.WithString("content",
+			mcp.Description("The content of the file"),
+			mcp.Required(),
+		),
+		mcp.WithString("mode",
+			mcp.Description("The permission bits in octal, e.g. \"0755\""),
+			mcp.Pattern("^0?[0-7]{3}$"),
+		),
+	),
+	Group: bitrise.GroupTransfer,
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		machineID, err := request.RequireString("machine_id")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		filePath, err := request.RequireString("path")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		content, err := request.RequireString("content")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if len(content) > maxInlineFileBytes {
+			return mcp.NewToolResultError(fmt.Sprintf(
+				"the content is %d bytes, larger than the %d bytes this tool can write. Save it to a local file and use bitrise_remote_machine_upload instead.",
+				len(content), maxInlineFileBytes)), nil
+		}
+		if strings.ContainsRune(content, 0) {
+			return mcp.NewToolResultError("the content contains NUL bytes. Use bitrise_remote_machine_upload for binary files."), nil
+		}
+
+		mode := request.GetString("mode", "")
+		if mode != "" {
+			if _, err := strconv.ParseUint(mode, 8, 32); err != nil || len(mode) > 4 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid mode %q, it must be octal permission bits like \"0644\"", mode)), nil
+			}
+		}
+
+		var res *bitrise.CommandResult
+		if len(content) <= maxStdinWriteBytes {
+			res, err = bitrise.Machines(ctx).Run(ctx, machineID, bitrise.Command{
+				Script: writeFileScript(filePath, mode),
+				Stdin:  content,
+			})
+		} else {
+			res, err = uploadAndWriteFile(ctx, machineID, filePath, mode, content)
+		}
+		if err != nil {
+			return newToolResultAPIError("failed to write file", err), nil
+		}
+		if res.ExitCode != 0 {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to write %s: exit code %d: %s", filePath, res.ExitCode, strings.TrimSpace(res.Stderr))), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Successfully wrote %d bytes to %s on machine %s", len(content), filePath, machineID)), nil
+	},
+}
+
+// maxStdinWriteBytes is the size up to which the content is sent as the stdin
+// of the command writing the file. Larger content is uploaded first, as the
+// size of commands is limited.
+const maxStdinWriteBytes = 64 << 10
+
+// uploadAndWriteFile uploads the content into a staging folder in the home
+// directory, and writes the file from there.
+func uploadAndWriteFile(ctx context.Context, machineID, filePath, mode, content string) (*bitrise.CommandResult, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generate upload ID: %w", err)
+	}
+	staging := ".bitrise-mcp-write-" + hex.EncodeToString(b)
+
+	machines := bitrise.Machines(ctx)
+	if _, err := uploadPartWithRetries(ctx, machines, machineID, staging, 0, []byte(content), nil); err != nil {
+		removeStagingFolder(ctx, machines, machineID, ".", staging)
+		return nil, fmt.Errorf("upload content: %w", err)
+	}
+	script := fmt.Sprintf("s=%s\n(\n%s\n) <\"$s/part-000000\"\nc=$?\nrm -rf \"$s\"\nexit $c",
+		bitrise.ShellQuote(staging), writeFileScript(filePath, mode))
+	res, err := machines.Run(ctx, machineID, bitrise.Command{Script: script})
+	if err != nil {
+		removeStagingFolder(ctx, machines, machineID, ".", staging)
+		return nil, err
+	}
+	return res, nil
+}
+
+// writeFileScript returns a script that writes its standard input to the file.
+func writeFileScript(filePath, mode string) string {
+	script := fmt.Sprintf(`f=%s
+if [ -d "$f" ]; then echo "is a directory" >&2; exit 1; fi
+mkdir -p "$(dirname "$f")" && cat >"$f"`, bitrise.ShellQuote(remoteShellPath(filePath)))
+	if mode != "" {
+		script += " && chmod " + mode + ` "$f"`
+	}
+	return script
+}
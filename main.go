@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
 	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/tool"
@@ -21,9 +26,57 @@ var BuildVersion = development //nolint:gochecknoglobals
 
 type config struct {
 	// BitriseToken is the Bitrise API token used to authenticate requests.
-	BitriseToken string `env:"BITRISE_TOKEN" required:"true"`
+	// It is required for the stdio transport. The HTTP based transports use it
+	// as a fallback for sessions that don't send their own token.
+	BitriseToken string `env:"BITRISE_TOKEN"`
 	// LogLevel is the log level for the application.
 	LogLevel string `env:"LOG_LEVEL" default:"info"`
+	// Transport is the MCP transport to serve: stdio, sse or http (streamable HTTP).
+	Transport string `env:"MCP_TRANSPORT" default:"stdio"`
+	// ListenAddr is the address the sse and http transports listen on.
+	ListenAddr string `env:"MCP_LISTEN_ADDR" default:"localhost:8080"`
+	// MultiTenant makes every session authenticate with its own Bitrise token,
+	// sent in the Authorization header or in the session metadata. BitriseToken
+	// is never used in this mode.
+	MultiTenant bool `env:"MCP_MULTI_TENANT" default:"false"`
+	// ValidateTokens checks the session's Bitrise token against the API when the
+	// session starts in multi-tenant mode.
+	ValidateTokens bool `env:"MCP_VALIDATE_TOKENS" default:"false"`
+	// ToolGroups is a comma separated list of the tool groups to expose
+	// (lifecycle, exec, transfer, gui, vnc). All groups are exposed if empty.
+	ToolGroups string `env:"MCP_TOOL_GROUPS"`
+	// ReadOnly exposes only the tools that don't modify the remote machine.
+	ReadOnly bool `env:"MCP_READ_ONLY" default:"false"`
+	// APITimeout is the timeout of a single Bitrise API request. Command
+	// execution is only limited by the lifetime of the remote machine.
+	APITimeout time.Duration `env:"BITRISE_API_TIMEOUT" default:"5m"`
+	// APIMaxRetries is how many times failed idempotent Bitrise API requests are retried.
+	APIMaxRetries int `env:"BITRISE_API_MAX_RETRIES" default:"3"`
+	// OutputHeadBytes and OutputTailBytes are how many bytes of the beginning
+	// and of the end of stdout and stderr of commands are returned to the agent.
+	OutputHeadBytes int `env:"MCP_OUTPUT_HEAD_BYTES" default:"8192"`
+	OutputTailBytes int `env:"MCP_OUTPUT_TAIL_BYTES" default:"8192"`
+	// CommandLogDir is the local directory the full output of commands is saved
+	// in for 24 hours. A directory in the user's cache directory is used if empty.
+	CommandLogDir string `env:"MCP_COMMAND_LOG_DIR"`
+	// SyncManifestDir is the local directory the manifests of folders synced
+	// to machines are kept in. A directory in the user's cache directory is used if empty.
+	SyncManifestDir string `env:"MCP_SYNC_MANIFEST_DIR"`
+	// UploadPartBytes is the size of the parts uploads are split into.
+	UploadPartBytes int `env:"MCP_UPLOAD_PART_BYTES" default:"33554432"`
+	// DownloadMaxBytes, DownloadMaxFiles and DownloadMaxFileBytes limit what
+	// a download may extract: the total bytes, the number of files and the
+	// size of a single file. 0 disables a limit.
+	DownloadMaxBytes     int64 `env:"MCP_DOWNLOAD_MAX_BYTES" default:"21474836480"`
+	DownloadMaxFiles     int   `env:"MCP_DOWNLOAD_MAX_FILES" default:"1000000"`
+	DownloadMaxFileBytes int64 `env:"MCP_DOWNLOAD_MAX_FILE_BYTES" default:"10737418240"`
+	// ResourcePollInterval is how often the remote files sessions subscribed
+	// to are checked for changes.
+	ResourcePollInterval time.Duration `env:"MCP_RESOURCE_POLL_INTERVAL" default:"5s"`
+	// MachineSpecsFile is a JSON file with the catalog of the stacks and
+	// machine types machines can be created with, in the format of the API.
+	// The catalog is fetched from the API if empty.
+	MachineSpecsFile string `env:"MCP_MACHINE_SPECS_FILE"`
 }
 
 func main() {
@@ -37,33 +90,137 @@ func run() error {
 	if err := configor.Load(&cfg); err != nil {
 		return fmt.Errorf("load configuration: %w", err)
 	}
+	if cfg.Transport == transportStdio {
+		if cfg.MultiTenant {
+			return errors.New("multi-tenant mode requires the sse or http transport")
+		}
+		if cfg.BitriseToken == "" {
+			return errors.New("BITRISE_TOKEN is required for the stdio transport")
+		}
+	}
 
 	logger, err := newStructuredLogger(cfg.LogLevel)
 	if err != nil {
 		return fmt.Errorf("initialize logger: %w", err)
 	}
 
-	toolBelt := tool.NewBelt()
+	apiClient := bitrise.NewClient(
+		bitrise.WithTimeout(cfg.APITimeout),
+		bitrise.WithMaxRetries(cfg.APIMaxRetries),
+	)
+
+	beltOpts := []tool.BeltOption{
+		tool.WithOutputLimit(cfg.OutputHeadBytes, cfg.OutputTailBytes),
+		tool.WithUploadPartSize(cfg.UploadPartBytes),
+		tool.WithDownloadLimits(cfg.DownloadMaxBytes, cfg.DownloadMaxFiles, cfg.DownloadMaxFileBytes),
+		tool.WithResourcePollInterval(cfg.ResourcePollInterval),
+	}
+	if cfg.CommandLogDir != "" {
+		beltOpts = append(beltOpts, tool.WithCommandLogDir(cfg.CommandLogDir))
+	}
+	if cfg.SyncManifestDir != "" {
+		beltOpts = append(beltOpts, tool.WithSyncManifestDir(cfg.SyncManifestDir))
+	}
+	if cfg.ReadOnly {
+		beltOpts = append(beltOpts, tool.WithReadOnly())
+	}
+	if cfg.MachineSpecsFile != "" {
+		specs, err := loadMachineSpecs(cfg.MachineSpecsFile)
+		if err != nil {
+			return err
+		}
+		beltOpts = append(beltOpts, tool.WithMachineSpecs(specs))
+	}
+	toolBelt := tool.NewBelt(beltOpts...)
 	mcpServer := server.NewMCPServer(
 		"bitrise",
 		"2.0.0",
 		server.WithRecovery(),
 		server.WithToolCapabilities(false),
+		// Subscriptions are handled by the transports, which the SSE transport can't do.
+		server.WithResourceCapabilities(cfg.Transport != transportSSE, false),
 		server.WithLogging(),
 	)
-	toolBelt.RegisterAll(mcpServer)
-
+	if err := toolBelt.RegisterGroups(mcpServer, splitList(cfg.ToolGroups)); err != nil {
+		return fmt.Errorf("register tools: %w", err)
+	}
+	server.WithToolFilter(toolBelt.ToolFilter)(mcpServer)
+	server.WithToolHandlerMiddleware(toolBelt.Middleware)(mcpServer)
 	server.WithToolHandlerMiddleware(func(fn server.ToolHandlerFunc) server.ToolHandlerFunc {
 		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			return fn(bitrise.ContextWithPAT(ctx, cfg.BitriseToken), request)
+			return fn(bitrise.ContextWithClient(ctx, apiClient), request)
 		}
 	})(mcpServer)
 
-	logger.Info("starting stdio transport")
-	if err := server.ServeStdio(mcpServer); err != nil {
-		return fmt.Errorf("serve stdio: %w", err)
+	hooks := &server.Hooks{}
+	toolBelt.RegisterHooks(hooks)
+	// withPAT attaches the token of the session to the context of resource
+	// reads and subscriptions, which the tool middlewares don't cover.
+	var withPAT func(ctx context.Context, sessionID string) context.Context
+	sessionClosed := func(string) {}
+	if cfg.MultiTenant {
+		auth := newTenantAuth(apiClient, cfg.ValidateTokens, logger)
+		auth.registerHooks(hooks)
+		server.WithToolHandlerMiddleware(auth.middleware)(mcpServer)
+		withPAT = auth.contextWithSessionPAT
+		sessionClosed = auth.forgetSession
+	} else {
+		withPAT = func(ctx context.Context, _ string) context.Context {
+			if !bitrise.HasPAT(ctx) && cfg.BitriseToken != "" {
+				ctx = bitrise.ContextWithPAT(ctx, cfg.BitriseToken)
+			}
+			return ctx
+		}
+		server.WithToolHandlerMiddleware(func(fn server.ToolHandlerFunc) server.ToolHandlerFunc {
+			return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return fn(withPAT(ctx, ""), request)
+			}
+		})(mcpServer)
+	}
+	server.WithHooks(hooks)(mcpServer)
+
+	resourceContext := func(ctx context.Context, sessionID string) context.Context {
+		return withPAT(bitrise.ContextWithClient(ctx, apiClient), sessionID)
+	}
+	server.WithResourceHandlerMiddleware(toolBelt.ResourceMiddleware)(mcpServer)
+	server.WithResourceHandlerMiddleware(func(fn server.ResourceHandlerFunc) server.ResourceHandlerFunc {
+		return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			var sessionID string
+			if session := server.ClientSessionFromContext(ctx); session != nil {
+				sessionID = session.SessionID()
+			}
+			return fn(resourceContext(ctx, sessionID), request)
+		}
+	})(mcpServer)
+	subscriptions := func(ctx context.Context, sessionID string, message json.RawMessage) mcp.JSONRPCMessage {
+		return toolBelt.HandleSubscription(resourceContext(ctx, sessionID), sessionID, message)
+	}
+
+	return serve(mcpServer, cfg, logger, subscriptions, sessionClosed)
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadMachineSpecs reads the catalog of machine specs from a JSON file.
+func loadMachineSpecs(path string) (*bitrise.MachineSpecs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read machine specs: %w", err)
+	}
+	var specs bitrise.MachineSpecs
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("parse machine specs %s: %w", path, err)
 	}
-	return nil
+	return &specs, nil
 }
 
 func newStructuredLogger(level string) (*zap.SugaredLogger, error) {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/tool"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// subscriptionHandler handles a resources/subscribe or resources/unsubscribe
+// request of a session, and returns the response. mcp-go doesn't implement
+// these methods, so the stdio and the streamable HTTP transports intercept
+// them before the MCP server sees them.
+type subscriptionHandler func(ctx context.Context, sessionID string, message json.RawMessage) mcp.JSONRPCMessage
+
+// stdioSessionID is the ID of the only session of the stdio transport.
+const stdioSessionID = "stdio"
+
+// serveStdio serves the stdio transport like server.ServeStdio does, except
+// for the subscription requests, which go to the handler.
+func serveStdio(mcpServer *server.MCPServer, subscriptions subscriptionHandler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stdout := &lineWriter{w: os.Stdout}
+	stdin, forward := io.Pipe()
+	go func() {
+		_ = forward.CloseWithError(interceptStdioSubscriptions(ctx, os.Stdin, forward, stdout, subscriptions))
+	}()
+	return server.NewStdioServer(mcpServer).Listen(ctx, stdin, stdout)
+}
+
+// interceptStdioSubscriptions copies the messages from in to forward, except
+// for the subscription requests, whose responses are written to out.
+func interceptStdioSubscriptions(ctx context.Context, in io.Reader, forward io.Writer, out io.Writer, subscriptions subscriptionHandler) error {
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if message := bytes.TrimSpace(line); tool.IsSubscriptionRequest(message) {
+			// Subscribing checks the file on the machine first, which must not
+			// hold up the other requests.
+			go func() {
+				if response, err := json.Marshal(subscriptions(ctx, stdioSessionID, message)); err == nil {
+					_, _ = out.Write(append(response, '\n'))
+				}
+			}()
+		} else if len(line) > 0 {
+			if _, err := forward.Write(line); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lineWriter serializes the writes of the stdio server and of the
+// subscription responses, each of which is a whole message.
+type lineWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// interceptSubscriptions passes the subscription requests POSTed to the
+// streamable HTTP endpoint to the handler, and every other request to next.
+func interceptSubscriptions(next http.Handler, subscriptions subscriptionHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read the request body", http.StatusBadRequest)
+			return
+		}
+		if !tool.IsSubscriptionRequest(body) {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionID := r.Header.Get(server.HeaderKeySessionID)
+		response := subscriptions(httpContextFunc(r.Context(), r), sessionID, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(server.HeaderKeySessionID, sessionID)
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
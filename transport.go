@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/bitrise-mcp-macos-remote-machine/internal/bitrise"
+	"github.com/mark3labs/mcp-go/server"
+	"go.uber.org/zap"
+)
+
+const (
+	transportStdio          = "stdio"
+	transportSSE            = "sse"
+	transportStreamableHTTP = "http"
+
+	shutdownTimeout = 10 * time.Second
+
+	// streamableEndpoint is the path of the streamable HTTP transport.
+	streamableEndpoint = "/mcp"
+
+	// headerToolGroups lets HTTP clients narrow down the tool groups of their session.
+	headerToolGroups = "X-Bitrise-Tool-Groups"
+)
+
+// httpTransport is implemented by both the SSE and the streamable HTTP server.
+type httpTransport interface {
+	Start(addr string) error
+	Shutdown(ctx context.Context) error
+}
+
+// serve serves the MCP server with the configured transport. sessionClosed is
+// called with the ID of the streamable HTTP sessions the clients close.
+func serve(mcpServer *server.MCPServer, cfg config, logger *zap.SugaredLogger, subscriptions subscriptionHandler, sessionClosed func(sessionID string)) error {
+	var transport httpTransport
+	switch cfg.Transport {
+	case transportStdio:
+		logger.Info("starting stdio transport")
+		if err := serveStdio(mcpServer, subscriptions); err != nil {
+			return fmt.Errorf("serve stdio: %w", err)
+		}
+		return nil
+	case transportSSE:
+		transport = server.NewSSEServer(mcpServer,
+			server.WithSSEContextFunc(httpContextFunc),
+		)
+	case transportStreamableHTTP:
+		httpServer := &http.Server{}
+		streamableServer := server.NewStreamableHTTPServer(mcpServer,
+			server.WithHTTPContextFunc(httpContextFunc),
+			server.WithStreamableHTTPServer(httpServer),
+		)
+		mux := http.NewServeMux()
+		mux.Handle(streamableEndpoint, notifySessionClosed(interceptSubscriptions(streamableServer, subscriptions), sessionClosed))
+		httpServer.Handler = mux
+		transport = streamableServer
+	default:
+		return fmt.Errorf("unsupported transport %q (expected %s, %s or %s)",
+			cfg.Transport, transportStdio, transportSSE, transportStreamableHTTP)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Infof("starting %s transport on %s", cfg.Transport, cfg.ListenAddr)
+		errCh <- transport.Start(cfg.ListenAddr)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve %s: %w", cfg.Transport, err)
+		}
+		return nil
+	case <-ctx.Done():
+		logger.Infof("shutting down %s transport", cfg.Transport)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := transport.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown %s: %w", cfg.Transport, err)
+		}
+		return nil
+	}
+}
+
+// httpContextFunc attaches the Bitrise PAT sent in the Authorization header
+// of an HTTP request to the context, so every session can use its own token
+// instead of the one from the configuration. It also applies the tool groups
+// requested by the client.
+func httpContextFunc(ctx context.Context, r *http.Request) context.Context {
+	if pat := patFromAuthorizationHeader(r.Header.Get("Authorization")); pat != "" {
+		ctx = bitrise.ContextWithPAT(ctx, pat)
+	}
+	if value := r.Header.Get(headerToolGroups); value != "" {
+		ctx = bitrise.ContextWithEnabledGroups(ctx, splitList(value))
+	}
+	return ctx
+}
+
+// patFromAuthorizationHeader accepts both the raw token (as the Bitrise API
+// does) and the "Bearer <token>" form most MCP clients send.
+func patFromAuthorizationHeader(value string) string {
+	value = strings.TrimSpace(value)
+	if scheme, token, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		value = strings.TrimSpace(token)
+	}
+	return value
+}
+
+// notifySessionClosed calls sessionClosed once a client closed its session
+// with a DELETE request. The streamable HTTP server terminates the session,
+// but doesn't unregister it from the MCP server.
+func notifySessionClosed(next http.Handler, sessionClosed func(sessionID string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if sessionID := r.Header.Get(server.HeaderKeySessionID); r.Method == http.MethodDelete && sessionID != "" {
+			sessionClosed(sessionID)
+		}
+	})
+}